@@ -0,0 +1,425 @@
+package collab
+
+import (
+	"errors"
+	"log"
+	"sync"
+	"time"
+	"unicode/utf8"
+
+	"github.com/frengine/server/auth"
+	"github.com/frengine/server/diff"
+	"github.com/frengine/server/ot"
+	"github.com/frengine/server/project"
+)
+
+// DefaultCheckpointInterval is how often the document of a session is saved
+// as a normal revision, if it changed.
+const DefaultCheckpointInterval = 30 * time.Second
+
+// maxHistory is how many operations a session remembers. Clients that are
+// further behind than that have to reconnect.
+const maxHistory = 1000
+
+// sendBuffer is how many messages can wait for a slow client, before it gets
+// disconnected.
+const sendBuffer = 64
+
+const checkpointMessage = "Collaborative editing"
+
+var ErrInvalidRevision = errors.New("revision out of range")
+
+// Message is sent between the server and the editors, as JSON.
+//
+// The server sends "init" with the document when a client joins, "ack" when
+// an operation of the client has been applied, and "op" for operations of
+// other clients (or of the server itself, without a user). Clients only send
+// "op", with the revision of the document the operation applies to.
+type Message struct {
+	Type     string        `json:"type"`
+	Revision int           `json:"revision"`
+	Op       *ot.Operation `json:"op,omitempty"`
+	Document *string       `json:"document,omitempty"`
+	User     *auth.User    `json:"user,omitempty"`
+	Error    string        `json:"error,omitempty"`
+}
+
+// Hub keeps a session for every project that is being edited.
+type Hub struct {
+	Store              project.Store
+	LogErr             *log.Logger
+	CheckpointInterval time.Duration
+
+	mu       sync.Mutex
+	sessions map[int]*Session
+	// closing has the sessions that ended but are still saving.
+	closing map[int]*Session
+}
+
+func NewHub(store project.Store, logErr *log.Logger, interval time.Duration) *Hub {
+	return &Hub{
+		Store:              store,
+		LogErr:             logErr,
+		CheckpointInterval: interval,
+		sessions:           map[int]*Session{},
+		closing:            map[int]*Session{},
+	}
+}
+
+type Client struct {
+	User auth.User
+
+	// Send gets the messages for the client. It's closed when the client is
+	// removed from the session.
+	Send chan Message
+}
+
+// Session is the document of a project that is being edited, with the
+// operations that led to it.
+type Session struct {
+	hub *Hub
+	pid int
+
+	mu       sync.Mutex
+	doc      string
+	revision int
+	history  []ot.Operation
+	clients  map[*Client]bool
+	// joined has the IDs of everyone who joined, even if they left since.
+	joined map[uint]bool
+
+	// The revision last saved, and its content, for merging in revisions
+	// saved outside of the session.
+	headID      int
+	headContent string
+	dirty       bool
+	lastAuthor  auth.User
+
+	// saving is held during a checkpoint, so they don't overlap.
+	saving sync.Mutex
+
+	stop chan struct{}
+	// done is closed when the session ended and the document is saved.
+	done chan struct{}
+}
+
+// Join adds a user to the session of the project, starting the session if
+// there is none yet. The client gets an init message first.
+func (h *Hub) Join(pid int, user auth.User) (*Session, *Client, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	// Wait for a session of the project that just ended to be saved, so the
+	// new one starts from the right revision.
+	for h.closing[pid] != nil {
+		done := h.closing[pid].done
+		h.mu.Unlock()
+		<-done
+		h.mu.Lock()
+	}
+
+	s, ok := h.sessions[pid]
+	if !ok {
+		rev, err := h.Store.FetchLatestRevisionByProject(pid)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		s = &Session{
+			hub:     h,
+			pid:     pid,
+			clients: map[*Client]bool{},
+			joined:  map[uint]bool{},
+			stop:    make(chan struct{}),
+			done:    make(chan struct{}),
+		}
+		if rev.ID != nil {
+			s.headID = *rev.ID
+		}
+		if rev.Content != nil {
+			s.doc = *rev.Content
+			s.headContent = *rev.Content
+		}
+
+		h.sessions[pid] = s
+		go s.checkpointLoop()
+	}
+
+	c := &Client{user, make(chan Message, sendBuffer)}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	doc := s.doc
+	c.Send <- Message{Type: "init", Revision: s.revision, Document: &doc}
+	s.clients[c] = true
+	s.joined[user.ID] = true
+
+	return s, c, nil
+}
+
+// Leave removes the client from the session. When the last one leaves, the
+// document is saved and the session ends.
+func (h *Hub) Leave(s *Session, c *Client) {
+	h.mu.Lock()
+
+	s.mu.Lock()
+	s.removeLocked(c)
+	empty := len(s.clients) == 0
+	s.mu.Unlock()
+
+	if !empty || h.sessions[s.pid] != s {
+		h.mu.Unlock()
+		return
+	}
+
+	delete(h.sessions, s.pid)
+	h.closing[s.pid] = s
+	h.mu.Unlock()
+
+	close(s.stop)
+	s.checkpoint()
+
+	h.mu.Lock()
+	delete(h.closing, s.pid)
+	h.mu.Unlock()
+
+	close(s.done)
+}
+
+// Receive applies an operation of a client, made on the given revision of
+// the document.
+func (s *Session) Receive(c *Client, revision int, op ot.Operation) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if !s.clients[c] {
+		return nil
+	}
+
+	err := s.applyLocked(revision, op, c)
+	if err != nil {
+		return err
+	}
+
+	s.lastAuthor = c.User
+
+	return nil
+}
+
+// SendError tells the client something went wrong, without blocking.
+func (s *Session) SendError(c *Client, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.sendLocked(c, Message{Type: "error", Revision: s.revision, Error: err.Error()})
+}
+
+func (s *Session) applyLocked(revision int, op ot.Operation, from *Client) error {
+	start := s.revision - len(s.history)
+	if revision < start || revision > s.revision {
+		return ErrInvalidRevision
+	}
+
+	// Transform the operation past everything that happened since the
+	// client's revision.
+	for _, other := range s.history[revision-start:] {
+		var err error
+		op, _, err = ot.Transform(op, other)
+		if err != nil {
+			return err
+		}
+	}
+
+	doc, err := op.Apply(s.doc)
+	if err != nil {
+		return err
+	}
+
+	s.doc = doc
+	s.revision++
+	s.history = append(s.history, op)
+	if len(s.history) > maxHistory {
+		s.history = s.history[len(s.history)-maxHistory:]
+	}
+	if !op.IsNoop() {
+		s.dirty = true
+	}
+
+	for c := range s.clients {
+		if c == from {
+			s.sendLocked(c, Message{Type: "ack", Revision: s.revision})
+			continue
+		}
+
+		msg := Message{Type: "op", Revision: s.revision, Op: &op}
+		if from != nil {
+			msg.User = &from.User
+		}
+		s.sendLocked(c, msg)
+	}
+
+	return nil
+}
+
+// sendLocked queues a message for the client. Clients that can't keep up are
+// dropped, which ends their connection.
+func (s *Session) sendLocked(c *Client, msg Message) {
+	if !s.clients[c] {
+		return
+	}
+
+	select {
+	case c.Send <- msg:
+	default:
+		s.hub.LogErr.Printf("collab: dropping slow client of project %d", s.pid)
+		s.removeLocked(c)
+	}
+}
+
+func (s *Session) removeLocked(c *Client) {
+	if s.clients[c] {
+		delete(s.clients, c)
+		close(c.Send)
+	}
+}
+
+// endLocked tells the clients why the session ends, and removes them. Their
+// changes since the last checkpoint are lost.
+func (s *Session) endLocked(err error) {
+	for c := range s.clients {
+		s.sendLocked(c, Message{Type: "error", Revision: s.revision, Error: err.Error()})
+		s.removeLocked(c)
+	}
+	s.dirty = false
+}
+
+func (s *Session) checkpointLoop() {
+	t := time.NewTicker(s.hub.CheckpointInterval)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-t.C:
+			s.checkpoint()
+		case <-s.stop:
+			return
+		}
+	}
+}
+
+// checkpoint saves the document as a revision if it changed. If a revision
+// was saved outside of the session in the meantime, it's merged into the
+// document. If someone who isn't in the session leased the project, the
+// session ends without saving.
+func (s *Session) checkpoint() {
+	s.saving.Lock()
+	defer s.saving.Unlock()
+
+	lease, err := s.hub.Store.FetchLease(s.pid)
+	if err != nil {
+		s.hub.LogErr.Println(err)
+		return
+	}
+
+	s.mu.Lock()
+	author := s.lastAuthor
+	if lease != nil {
+		if !s.joined[lease.Holder.ID] {
+			s.endLocked(project.ErrLeaseHeld)
+			s.mu.Unlock()
+			return
+		}
+		// Only the holder may save.
+		author = *lease.Holder
+	}
+	if !s.dirty {
+		s.mu.Unlock()
+		return
+	}
+	doc, revision := s.doc, s.revision
+	headID, headContent := s.headID, s.headContent
+	s.dirty = false
+	s.mu.Unlock()
+
+	store := s.hub.Store
+	content := doc
+
+	id, err := store.SaveRevision(s.pid, project.MainBranch, &headID, author, checkpointMessage, content)
+	if err == project.ErrConflict {
+		var latest project.Revision
+		latest, err = store.FetchLatestRevisionByProject(s.pid)
+		if err == nil {
+			latestID, latestContent := 0, ""
+			if latest.ID != nil {
+				latestID = *latest.ID
+			}
+			if latest.Content != nil {
+				latestContent = *latest.Content
+			}
+
+			// If the changes overlap, the session wins. The other revision
+			// stays in the history.
+			m := diff.Merge3(headContent, doc, latestContent, "session", "revision")
+			if m.Clean() {
+				content = m.Content
+			}
+
+			id, err = store.SaveRevision(s.pid, project.MainBranch, &latestID, author, checkpointMessage, content)
+		}
+	}
+	if err == project.ErrLeaseHeld {
+		// Someone outside of the session checked the project out, so its
+		// changes can't be saved anymore.
+		s.mu.Lock()
+		s.endLocked(err)
+		s.mu.Unlock()
+		return
+	}
+	if err != nil {
+		s.hub.LogErr.Println(err)
+
+		s.mu.Lock()
+		s.dirty = true
+		s.mu.Unlock()
+		return
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.headID = id
+	s.headContent = content
+
+	if content != doc {
+		// Bring the editors up to date with what was merged in.
+		err := s.applyLocked(revision, operationFromDiff(doc, content), nil)
+		if err != nil {
+			s.hub.LogErr.Println(err)
+			return
+		}
+
+		// That's what was just saved, unless someone edited in the meantime.
+		if s.revision == revision+1 {
+			s.dirty = false
+		}
+	}
+}
+
+// operationFromDiff makes an operation that turns a into b.
+func operationFromDiff(a, b string) ot.Operation {
+	op := ot.Operation{}
+
+	for _, l := range diff.Strings(a, b) {
+		switch l.Op {
+		case diff.OpEqual:
+			op.Retain(utf8.RuneCountInString(l.Text))
+		case diff.OpDelete:
+			op.Delete(utf8.RuneCountInString(l.Text))
+		case diff.OpInsert:
+			op.Insert(l.Text)
+		}
+	}
+
+	return op
+}
@@ -16,6 +16,21 @@ type Config struct {
 		Port     int    `json:"port"`
 	} `json:"db"`
 	JWTSecret string `json:"jwtSecret"`
+	// AllowedOrigins are the web pages, besides the server itself, that may
+	// open a collaborative editing WebSocket, e.g. "https://example.com".
+	AllowedOrigins []string `json:"allowedOrigins"`
+	// Admins are the IDs of the accounts that curate the templates.
+	Admins  []uint `json:"admins"`
+	Storage struct {
+		// SnapshotInterval enables delta compressed revisions, see
+		// project.PostgresStore.
+		SnapshotInterval int `json:"snapshotInterval"`
+	} `json:"storage"`
+	Trash struct {
+		// RetentionDays is how long deleted projects stay in the trash,
+		// before they're purged. 0 keeps them forever.
+		RetentionDays int `json:"retentionDays"`
+	} `json:"trash"`
 }
 
 var ErrFileNotExists = os.ErrNotExist
@@ -55,7 +70,15 @@ var defaultJSON = []byte(`{
 		"host": "localhost",
 		"port": 3306
 	},
-	"jwtSecret": "secret for generating JWT keys here"
+	"jwtSecret": "secret for generating JWT keys here",
+	"allowedOrigins": [],
+	"admins": [],
+	"storage": {
+		"snapshotInterval": 0
+	},
+	"trash": {
+		"retentionDays": 30
+	}
 }
 `)
 
@@ -0,0 +1,134 @@
+package delta
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// The format is the length of the target as uvarint, followed by
+// instructions that each start with an opcode byte:
+//
+//	opCopy, offset uvarint, length uvarint: copy length bytes from the base
+//	opInsert, length uvarint, data: append the data that follows
+const (
+	opCopy   = 1
+	opInsert = 2
+)
+
+// blockSize is the smallest run of bytes that is looked up in the base.
+const blockSize = 16
+
+var ErrCorrupt = errors.New("corrupt delta")
+
+// Make returns a delta that turns base into target with Apply.
+func Make(base, target []byte) []byte {
+	out := binary.AppendUvarint(nil, uint64(len(target)))
+
+	// Index the base by its blocks. Only the first occurrence is kept, which
+	// is good enough for text that mostly grows.
+	index := map[string]int{}
+	for i := 0; i+blockSize <= len(base); i += blockSize {
+		block := string(base[i : i+blockSize])
+		if _, ok := index[block]; !ok {
+			index[block] = i
+		}
+	}
+
+	insertFrom := 0
+	i := 0
+	for i+blockSize <= len(target) {
+		offset, ok := index[string(target[i:i+blockSize])]
+		if !ok {
+			i++
+			continue
+		}
+
+		// Grow the match backwards into the pending insert, and forwards as
+		// far as the bytes are the same.
+		for offset > 0 && i > insertFrom && base[offset-1] == target[i-1] {
+			offset--
+			i--
+		}
+		length := 0
+		for offset+length < len(base) && i+length < len(target) && base[offset+length] == target[i+length] {
+			length++
+		}
+
+		out = appendInsert(out, target[insertFrom:i])
+
+		out = append(out, opCopy)
+		out = binary.AppendUvarint(out, uint64(offset))
+		out = binary.AppendUvarint(out, uint64(length))
+
+		i += length
+		insertFrom = i
+	}
+
+	return appendInsert(out, target[insertFrom:])
+}
+
+func appendInsert(out []byte, data []byte) []byte {
+	if len(data) == 0 {
+		return out
+	}
+
+	out = append(out, opInsert)
+	out = binary.AppendUvarint(out, uint64(len(data)))
+	return append(out, data...)
+}
+
+// Apply rebuilds the target a delta was made for from its base.
+func Apply(base, delta []byte) ([]byte, error) {
+	size, n := binary.Uvarint(delta)
+	if n <= 0 {
+		return nil, ErrCorrupt
+	}
+	delta = delta[n:]
+
+	out := make([]byte, 0, size)
+
+	for len(delta) > 0 {
+		op := delta[0]
+		delta = delta[1:]
+
+		switch op {
+		case opCopy:
+			offset, n := binary.Uvarint(delta)
+			if n <= 0 {
+				return nil, ErrCorrupt
+			}
+			delta = delta[n:]
+
+			length, n := binary.Uvarint(delta)
+			if n <= 0 {
+				return nil, ErrCorrupt
+			}
+			delta = delta[n:]
+
+			if offset+length > uint64(len(base)) {
+				return nil, ErrCorrupt
+			}
+			out = append(out, base[offset:offset+length]...)
+		case opInsert:
+			length, n := binary.Uvarint(delta)
+			if n <= 0 {
+				return nil, ErrCorrupt
+			}
+			delta = delta[n:]
+
+			if length > uint64(len(delta)) {
+				return nil, ErrCorrupt
+			}
+			out = append(out, delta[:length]...)
+			delta = delta[length:]
+		default:
+			return nil, ErrCorrupt
+		}
+	}
+
+	if uint64(len(out)) != size {
+		return nil, ErrCorrupt
+	}
+
+	return out, nil
+}
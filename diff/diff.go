@@ -0,0 +1,305 @@
+package diff
+
+import (
+	"fmt"
+	"strings"
+)
+
+type Op string
+
+const (
+	OpEqual  Op = "equal"
+	OpInsert Op = "insert"
+	OpDelete Op = "delete"
+)
+
+type Line struct {
+	Op   Op     `json:"op"`
+	Text string `json:"text"`
+}
+
+type Hunk struct {
+	OldStart int    `json:"oldStart"`
+	OldLines int    `json:"oldLines"`
+	NewStart int    `json:"newStart"`
+	NewLines int    `json:"newLines"`
+	Lines    []Line `json:"lines"`
+}
+
+// SplitLines splits s into lines, keeping the line endings. This way a
+// missing newline at the end of the text shows up as a change too.
+func SplitLines(s string) []string {
+	if s == "" {
+		return []string{}
+	}
+
+	lines := strings.SplitAfter(s, "\n")
+	if lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+	}
+
+	return lines
+}
+
+// Compute returns the shortest edit script from a to b, using the linear
+// space variant of the Myers algorithm: it finds the middle of the edit
+// script and diffs both halves on their own.
+func Compute(a, b []string) []Line {
+	return compute(a, b, make([]Line, 0, len(a)+len(b)))
+}
+
+// compute appends the edit script from a to b to lines.
+func compute(a, b []string, lines []Line) []Line {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	for _, l := range a[:prefix] {
+		lines = append(lines, Line{OpEqual, l})
+	}
+	a, b = a[prefix:], b[prefix:]
+
+	suffix := 0
+	for suffix < len(a) && suffix < len(b) && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+	common := a[len(a)-suffix:]
+	a, b = a[:len(a)-suffix], b[:len(b)-suffix]
+
+	if x, y, ok := middleSnake(a, b); ok {
+		lines = compute(a[:x], b[:y], lines)
+		lines = compute(a[x:], b[y:], lines)
+	} else {
+		for _, l := range a {
+			lines = append(lines, Line{OpDelete, l})
+		}
+		for _, l := range b {
+			lines = append(lines, Line{OpInsert, l})
+		}
+	}
+
+	for _, l := range common {
+		lines = append(lines, Line{OpEqual, l})
+	}
+
+	return lines
+}
+
+// middleSnake searches the shortest edit script from a to b from both ends
+// at once, and returns where the two searches meet. Both halves of the script
+// are shorter than the whole. It returns false when a and b have nothing in
+// common, or one of them is empty.
+func middleSnake(a, b []string) (int, int, bool) {
+	n, m := len(a), len(b)
+	if n == 0 || m == 0 {
+		return 0, 0, false
+	}
+
+	max := (n + m + 1) / 2
+	offset := max
+	delta := n - m
+	odd := delta%2 != 0
+
+	// vf has the furthest x reached going forward on every diagonal k = x-y,
+	// vb the same going backward, counted from the ends of a and b.
+	vf := make([]int, 2*max+2)
+	vb := make([]int, 2*max+2)
+	for i := range vf {
+		vf[i] = -1
+		vb[i] = -1
+	}
+	vf[offset+1] = 0
+	vb[offset+1] = 0
+
+	// Diagonals that ran off the edge of a or b aren't searched any further.
+	fStart, fEnd, bStart, bEnd := 0, 0, 0, 0
+
+	for d := 0; d < max; d++ {
+		for k := -d + fStart; k <= d-fEnd; k += 2 {
+			var x int
+			if k == -d || (k != d && vf[offset+k-1] < vf[offset+k+1]) {
+				x = vf[offset+k+1]
+			} else {
+				x = vf[offset+k-1] + 1
+			}
+			y := x - k
+
+			for x < n && y < m && a[x] == b[y] {
+				x++
+				y++
+			}
+
+			vf[offset+k] = x
+
+			switch {
+			case x > n:
+				fEnd += 2
+			case y > m:
+				fStart += 2
+			case odd:
+				bk := offset + delta - k
+				if bk >= 0 && bk < len(vb) && vb[bk] != -1 && x >= n-vb[bk] {
+					return x, y, true
+				}
+			}
+		}
+
+		for k := -d + bStart; k <= d-bEnd; k += 2 {
+			var x int
+			if k == -d || (k != d && vb[offset+k-1] < vb[offset+k+1]) {
+				x = vb[offset+k+1]
+			} else {
+				x = vb[offset+k-1] + 1
+			}
+			y := x - k
+
+			for x < n && y < m && a[n-x-1] == b[m-y-1] {
+				x++
+				y++
+			}
+
+			vb[offset+k] = x
+
+			switch {
+			case x > n:
+				bEnd += 2
+			case y > m:
+				bStart += 2
+			case !odd:
+				fk := offset + delta - k
+				if fk >= 0 && fk < len(vf) && vf[fk] != -1 && vf[fk] >= n-x {
+					fx := vf[fk]
+					return fx, fx - (fk - offset), true
+				}
+			}
+		}
+	}
+
+	return 0, 0, false
+}
+
+// Strings diffs two texts line by line.
+func Strings(a, b string) []Line {
+	return Compute(SplitLines(a), SplitLines(b))
+}
+
+// MakeHunks groups an edit script into hunks, with context unchanged lines
+// around every change.
+func MakeHunks(lines []Line, context int) []Hunk {
+	if context < 0 {
+		context = 0
+	}
+
+	// Line numbers (0-based) in the old and new text before lines[i].
+	oldAt := make([]int, len(lines)+1)
+	newAt := make([]int, len(lines)+1)
+	for i, l := range lines {
+		oldAt[i+1] = oldAt[i]
+		newAt[i+1] = newAt[i]
+		if l.Op != OpInsert {
+			oldAt[i+1]++
+		}
+		if l.Op != OpDelete {
+			newAt[i+1]++
+		}
+	}
+
+	hunks := []Hunk{}
+	n := len(lines)
+
+	for i := 0; i < n; {
+		if lines[i].Op == OpEqual {
+			i++
+			continue
+		}
+
+		start := i - context
+		if start < 0 {
+			start = 0
+		}
+
+		end := i
+		for end < n {
+			if lines[end].Op != OpEqual {
+				end++
+				continue
+			}
+
+			run := end
+			for run < n && lines[run].Op == OpEqual {
+				run++
+			}
+
+			if run == n || run-end > 2*context {
+				end += context
+				if end > n {
+					end = n
+				}
+				break
+			}
+
+			end = run
+		}
+
+		h := Hunk{
+			OldStart: oldAt[start] + 1,
+			OldLines: oldAt[end] - oldAt[start],
+			NewStart: newAt[start] + 1,
+			NewLines: newAt[end] - newAt[start],
+			Lines:    lines[start:end],
+		}
+		// Empty ranges point at the line before them, like diff(1) does.
+		if h.OldLines == 0 {
+			h.OldStart--
+		}
+		if h.NewLines == 0 {
+			h.NewStart--
+		}
+
+		hunks = append(hunks, h)
+
+		i = end
+	}
+
+	return hunks
+}
+
+// Unified formats hunks as a unified diff.
+func Unified(oldName, newName string, hunks []Hunk) string {
+	if len(hunks) == 0 {
+		return ""
+	}
+
+	sb := strings.Builder{}
+
+	fmt.Fprintf(&sb, "--- %s\n+++ %s\n", oldName, newName)
+
+	for _, h := range hunks {
+		fmt.Fprintf(&sb, "@@ -%s +%s @@\n", hunkRange(h.OldStart, h.OldLines), hunkRange(h.NewStart, h.NewLines))
+
+		for _, l := range h.Lines {
+			switch l.Op {
+			case OpEqual:
+				sb.WriteByte(' ')
+			case OpInsert:
+				sb.WriteByte('+')
+			case OpDelete:
+				sb.WriteByte('-')
+			}
+
+			sb.WriteString(l.Text)
+			if !strings.HasSuffix(l.Text, "\n") {
+				sb.WriteString("\n\\ No newline at end of file\n")
+			}
+		}
+	}
+
+	return sb.String()
+}
+
+func hunkRange(start, lines int) string {
+	if lines == 1 {
+		return fmt.Sprintf("%d", start)
+	}
+	return fmt.Sprintf("%d,%d", start, lines)
+}
@@ -0,0 +1,135 @@
+package diff
+
+import (
+	"strings"
+)
+
+type Conflict struct {
+	// Line is where the conflict markers start in the merged content, 1-based.
+	Line   int      `json:"line"`
+	Base   []string `json:"base"`
+	Ours   []string `json:"ours"`
+	Theirs []string `json:"theirs"`
+}
+
+type MergeResult struct {
+	Content   string     `json:"content"`
+	Conflicts []Conflict `json:"conflicts"`
+}
+
+func (m MergeResult) Clean() bool {
+	return len(m.Conflicts) == 0
+}
+
+// Merge3 does a line based three-way merge of two texts that were both
+// changed from base. Changes that don't overlap are combined; overlapping
+// ones are written out between conflict markers, in the style of diff3(1),
+// and listed in Conflicts.
+func Merge3(base, ours, theirs string, oursLabel, theirsLabel string) MergeResult {
+	b := SplitLines(base)
+	o := SplitLines(ours)
+	t := SplitLines(theirs)
+
+	matchO := matches(b, o)
+	matchT := matches(b, t)
+
+	out := []string{}
+	conflicts := []Conflict{}
+
+	i, io, it := 0, 0, 0
+	for i < len(b) || io < len(o) || it < len(t) {
+		// Lines that are unchanged on both sides.
+		if i < len(b) && matchO[i] == io && matchT[i] == it {
+			out = append(out, b[i])
+			i++
+			io++
+			it++
+			continue
+		}
+
+		// Find the next base line both sides kept; everything before it is a
+		// changed chunk.
+		next := i
+		for next < len(b) && (matchO[next] < 0 || matchT[next] < 0) {
+			next++
+		}
+
+		endO, endT := len(o), len(t)
+		if next < len(b) {
+			endO, endT = matchO[next], matchT[next]
+		}
+
+		bc, oc, tc := b[i:next], o[io:endO], t[it:endT]
+
+		switch {
+		case equalLines(oc, bc):
+			out = append(out, tc...)
+		case equalLines(tc, bc), equalLines(oc, tc):
+			out = append(out, oc...)
+		default:
+			conflicts = append(conflicts, Conflict{
+				Line:   len(out) + 1,
+				Base:   bc,
+				Ours:   oc,
+				Theirs: tc,
+			})
+
+			out = append(out, "<<<<<<< "+oursLabel+"\n")
+			out = appendTerminated(out, oc)
+			out = append(out, "||||||| base\n")
+			out = appendTerminated(out, bc)
+			out = append(out, "=======\n")
+			out = appendTerminated(out, tc)
+			out = append(out, ">>>>>>> "+theirsLabel+"\n")
+		}
+
+		i, io, it = next, endO, endT
+	}
+
+	return MergeResult{strings.Join(out, ""), conflicts}
+}
+
+// matches returns, for every line in a, the index of the line in b it was
+// kept as, or -1 if it was removed.
+func matches(a, b []string) []int {
+	m := make([]int, len(a))
+
+	ia, ib := 0, 0
+	for _, l := range Compute(a, b) {
+		switch l.Op {
+		case OpEqual:
+			m[ia] = ib
+			ia++
+			ib++
+		case OpDelete:
+			m[ia] = -1
+			ia++
+		case OpInsert:
+			ib++
+		}
+	}
+
+	return m
+}
+
+func equalLines(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// appendTerminated appends lines, making sure the last one ends with a
+// newline so a conflict marker can follow it.
+func appendTerminated(out []string, lines []string) []string {
+	out = append(out, lines...)
+	if len(lines) > 0 && !strings.HasSuffix(lines[len(lines)-1], "\n") {
+		out[len(out)-1] += "\n"
+	}
+	return out
+}
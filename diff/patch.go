@@ -0,0 +1,178 @@
+package diff
+
+import (
+	"errors"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+var (
+	ErrInvalidPatch = errors.New("invalid patch")
+	ErrPatchFailed  = errors.New("patch does not apply")
+)
+
+var hunkHeaderRegexp = regexp.MustCompile(`^@@ -(\d+)(?:,(\d+))? \+(\d+)(?:,(\d+))? @@`)
+
+// Parse reads the hunks of a unified diff, as made by Unified or diff -u.
+// File headers and anything else outside of hunks is ignored.
+func Parse(patch string) ([]Hunk, error) {
+	hunks := []Hunk{}
+
+	lines := strings.Split(patch, "\n")
+	for i := 0; i < len(lines); i++ {
+		m := hunkHeaderRegexp.FindStringSubmatch(lines[i])
+		if m == nil {
+			continue
+		}
+
+		h := Hunk{
+			OldStart: atoi(m[1], 0),
+			OldLines: atoi(m[2], 1),
+			NewStart: atoi(m[3], 0),
+			NewLines: atoi(m[4], 1),
+			Lines:    []Line{},
+		}
+
+		oldN, newN := 0, 0
+		for oldN < h.OldLines || newN < h.NewLines {
+			i++
+			if i >= len(lines) {
+				return hunks, ErrInvalidPatch
+			}
+
+			l := lines[i]
+			if l == "" {
+				// Some tools strip the space of empty context lines.
+				l = " "
+			}
+
+			text := l[1:] + "\n"
+			switch l[0] {
+			case ' ':
+				h.Lines = append(h.Lines, Line{OpEqual, text})
+				oldN++
+				newN++
+			case '-':
+				h.Lines = append(h.Lines, Line{OpDelete, text})
+				oldN++
+			case '+':
+				h.Lines = append(h.Lines, Line{OpInsert, text})
+				newN++
+			case '\\':
+				stripNewline(h.Lines)
+			default:
+				return hunks, ErrInvalidPatch
+			}
+		}
+
+		if oldN != h.OldLines || newN != h.NewLines {
+			return hunks, ErrInvalidPatch
+		}
+
+		// The marker for a missing newline comes after the last line.
+		if i+1 < len(lines) && strings.HasPrefix(lines[i+1], `\`) {
+			i++
+			stripNewline(h.Lines)
+		}
+
+		hunks = append(hunks, h)
+	}
+
+	return hunks, nil
+}
+
+func atoi(s string, def int) int {
+	if s == "" {
+		return def
+	}
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+func stripNewline(lines []Line) {
+	if len(lines) > 0 {
+		last := &lines[len(lines)-1]
+		last.Text = strings.TrimSuffix(last.Text, "\n")
+	}
+}
+
+// Apply applies hunks to content. The context and removed lines have to match
+// exactly, at the line numbers the hunks say, or ErrPatchFailed is returned.
+func Apply(content string, hunks []Hunk) (string, error) {
+	lines := SplitLines(content)
+	out := []string{}
+	pos := 0
+
+	for _, h := range hunks {
+		// Empty ranges point at the line before them.
+		start := h.OldStart - 1
+		if h.OldLines == 0 {
+			start = h.OldStart
+		}
+
+		if start < pos || start > len(lines) {
+			return "", ErrPatchFailed
+		}
+
+		out = append(out, lines[pos:start]...)
+		pos = start
+
+		for _, l := range h.Lines {
+			switch l.Op {
+			case OpEqual, OpDelete:
+				if pos >= len(lines) || lines[pos] != l.Text {
+					return "", ErrPatchFailed
+				}
+				if l.Op == OpEqual {
+					out = append(out, l.Text)
+				}
+				pos++
+			case OpInsert:
+				out = append(out, l.Text)
+			}
+		}
+	}
+
+	out = append(out, lines[pos:]...)
+
+	return strings.Join(out, ""), nil
+}
+
+// Edit replaces the text between Start and End with Text. The offsets count
+// Unicode code points, not bytes.
+type Edit struct {
+	Start int    `json:"start"`
+	End   int    `json:"end"`
+	Text  string `json:"text"`
+}
+
+// ApplyEdits applies edits to content. All offsets are relative to content
+// and the edits can't overlap.
+func ApplyEdits(content string, edits []Edit) (string, error) {
+	runes := []rune(content)
+
+	sorted := make([]Edit, len(edits))
+	copy(sorted, edits)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return sorted[i].Start < sorted[j].Start
+	})
+
+	sb := strings.Builder{}
+	pos := 0
+
+	for _, e := range sorted {
+		if e.Start < pos || e.End < e.Start || e.End > len(runes) {
+			return "", ErrPatchFailed
+		}
+
+		sb.WriteString(string(runes[pos:e.Start]))
+		sb.WriteString(e.Text)
+		pos = e.End
+	}
+
+	sb.WriteString(string(runes[pos:]))
+
+	return sb.String(), nil
+}
@@ -0,0 +1,105 @@
+package events
+
+import (
+	"sync"
+	"time"
+)
+
+// Channel is the Postgres notification channel the events go over, so every
+// server instance gets them.
+const Channel = "project_events"
+
+const (
+	TypeRevision = "revision"
+	TypeUpdate   = "update"
+	TypeDelete   = "delete"
+	TypeUndelete = "undelete"
+)
+
+// Event is something that happened to a project. IDs only go up, so clients
+// can resume after the last event they saw.
+type Event struct {
+	ID        int64  `json:"id"`
+	ProjectID int    `json:"project"`
+	Type      string `json:"type"`
+
+	// For revision events.
+	RevisionID *int    `json:"revision,omitempty"`
+	Branch     *string `json:"branch,omitempty"`
+
+	// For update events.
+	Name *string `json:"name,omitempty"`
+
+	Created    *time.Time `json:"-"`
+	CreatedUTS int64      `json:"created"`
+}
+
+// subscriptionBuffer is how many events can wait for a slow subscriber,
+// before it gets dropped.
+const subscriptionBuffer = 64
+
+// Bus hands out events to everyone in this process that is interested. The
+// zero value is not usable, use NewBus. A nil *Bus drops everything.
+type Bus struct {
+	mu   sync.Mutex
+	subs map[*Subscription]bool
+}
+
+type Subscription struct {
+	// C gets the events. It's closed when unsubscribed, or when the
+	// subscriber couldn't keep up.
+	C chan Event
+
+	pid int
+}
+
+func NewBus() *Bus {
+	return &Bus{subs: map[*Subscription]bool{}}
+}
+
+// Subscribe to the events of a project, or of all projects if pid is 0.
+func (b *Bus) Subscribe(pid int) *Subscription {
+	sub := &Subscription{make(chan Event, subscriptionBuffer), pid}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.subs[sub] = true
+
+	return sub
+}
+
+func (b *Bus) Unsubscribe(sub *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.removeLocked(sub)
+}
+
+func (b *Bus) Publish(e Event) {
+	if b == nil {
+		return
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for sub := range b.subs {
+		if sub.pid != 0 && sub.pid != e.ProjectID {
+			continue
+		}
+
+		select {
+		case sub.C <- e:
+		default:
+			b.removeLocked(sub)
+		}
+	}
+}
+
+func (b *Bus) removeLocked(sub *Subscription) {
+	if b.subs[sub] {
+		delete(b.subs, sub)
+		close(sub.C)
+	}
+}
@@ -0,0 +1,157 @@
+// Package export writes projects with their whole history to archives, for
+// backups.
+//
+// An archive is a gzipped tar, with everything in a directory named
+// project-<id>:
+//
+//	project.json     The Manifest: the project, its branches, tags and
+//	                 members.
+//	revisions/<rid>  The content of revision <rid>, modified at the time the
+//	                 revision was saved.
+//	revisions.json   Every revision without its content, oldest first, with
+//	                 its parent, author, message, content hash and the Unix
+//	                 time it was saved.
+//
+// Timestamps in the JSON files are in Unix time, like in the API.
+package export
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"encoding/json"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/frengine/server/project"
+)
+
+// Format is the version of the archive layout, which changes when it does.
+const Format = 1
+
+type Manifest struct {
+	Format   int   `json:"format"`
+	Exported int64 `json:"exported"`
+
+	// Project is without its latest revision and lease.
+	Project  project.Project  `json:"project"`
+	Branches []project.Ref    `json:"branches"`
+	Tags     []project.Ref    `json:"tags"`
+	Members  []project.Member `json:"members"`
+}
+
+// Writer writes one project to an archive. WriteManifest has to be called
+// first, then WriteRevision for every revision, and Close at the end.
+type Writer struct {
+	gz *gzip.Writer
+	tw *tar.Writer
+
+	dir       string
+	revisions []project.Revision
+}
+
+func NewWriter(w io.Writer) *Writer {
+	gz := gzip.NewWriter(w)
+
+	return &Writer{
+		gz: gz,
+		tw: tar.NewWriter(gz),
+
+		revisions: []project.Revision{},
+	}
+}
+
+func (w *Writer) WriteManifest(m Manifest) error {
+	m.Format = Format
+	m.Exported = time.Now().Unix()
+	m.Project.Revision = nil
+	m.Project.Lease = nil
+
+	w.dir = "project-" + strconv.Itoa(m.Project.ID) + "/"
+
+	if err := w.writeDir(w.dir, time.Now()); err != nil {
+		return err
+	}
+	if err := w.writeDir(w.dir+"revisions/", time.Now()); err != nil {
+		return err
+	}
+
+	return w.writeJSON(w.dir+"project.json", m)
+}
+
+// WriteRevision adds the content of the revision to the archive, and keeps the
+// rest for revisions.json.
+func (w *Writer) WriteRevision(r project.Revision) error {
+	content := ""
+	if r.Content != nil {
+		content = *r.Content
+	}
+
+	modtime := time.Now()
+	if r.Created != nil {
+		modtime = *r.Created
+	}
+
+	name := w.dir + "revisions/"
+	if r.ID != nil {
+		name += strconv.Itoa(*r.ID)
+	}
+
+	err := w.writeFile(name, []byte(content), modtime)
+	if err != nil {
+		return err
+	}
+
+	r.Content = nil
+	w.revisions = append(w.revisions, r)
+
+	return nil
+}
+
+// Close writes revisions.json and finishes the archive. It doesn't close the
+// underlying writer.
+func (w *Writer) Close() error {
+	if err := w.writeJSON(w.dir+"revisions.json", w.revisions); err != nil {
+		return err
+	}
+
+	if err := w.tw.Close(); err != nil {
+		return err
+	}
+
+	return w.gz.Close()
+}
+
+func (w *Writer) writeDir(name string, modtime time.Time) error {
+	return w.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeDir,
+		Name:     name,
+		Mode:     0755,
+		ModTime:  modtime,
+	})
+}
+
+func (w *Writer) writeFile(name string, data []byte, modtime time.Time) error {
+	err := w.tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     name,
+		Mode:     0644,
+		Size:     int64(len(data)),
+		ModTime:  modtime,
+	})
+	if err != nil {
+		return err
+	}
+
+	_, err = w.tw.Write(data)
+	return err
+}
+
+func (w *Writer) writeJSON(name string, v interface{}) error {
+	data, err := json.MarshalIndent(v, "", "    ")
+	if err != nil {
+		return err
+	}
+
+	return w.writeFile(name, data, time.Now())
+}
@@ -152,11 +152,41 @@ type AuthWare struct {
 }
 
 func (mv AuthWare) Middleware(next http.Handler) http.Handler {
+	return mv.middleware(next, false, false)
+}
+
+// OptionalMiddleware lets requests without a token through anonymously, for
+// routes that show more to logged in users. A bad token is still refused.
+func (mv AuthWare) OptionalMiddleware(next http.Handler) http.Handler {
+	return mv.middleware(next, true, false)
+}
+
+// StreamMiddleware is Middleware for WebSockets and event streams, which
+// browsers can't set headers on. The token can be in the "token" query
+// parameter there. Other routes don't take it, so it doesn't end up in logs.
+func (mv AuthWare) StreamMiddleware(next http.Handler) http.Handler {
+	return mv.middleware(next, false, true)
+}
+
+// OptionalStreamMiddleware is OptionalMiddleware for streams, see
+// StreamMiddleware.
+func (mv AuthWare) OptionalStreamMiddleware(next http.Handler) http.Handler {
+	return mv.middleware(next, true, true)
+}
+
+func (mv AuthWare) middleware(next http.Handler, optional bool, fromQuery bool) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		key := r.Header.Get("Authorization")
+		if key == "" && fromQuery {
+			key = r.URL.Query().Get("token")
+		}
 
 		parts := strings.Split(key, " ")
 		key = parts[len(parts)-1]
+		if key == "" && optional {
+			next.ServeHTTP(w, r)
+			return
+		}
 		if key == "" {
 			respondError(w, r, http.StatusUnauthorized, "Authentication header empty")
 			return
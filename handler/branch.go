@@ -0,0 +1,114 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/frengine/server/project"
+	"github.com/gorilla/mux"
+)
+
+type BranchListHandler struct {
+	Deps
+}
+
+func (h BranchListHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pid, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	if _, ok := mustFetchProject(w, r, h.Deps, pid); !ok {
+		return
+	}
+
+	refs, err := h.Deps.ProjectStore.FetchBranches(pid)
+	if err != nil {
+		h.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	respondSuccess(w, r, refs, time.Time{})
+}
+
+type BranchCreateHandler struct {
+	Deps
+}
+
+type branchCreateReq struct {
+	Name string `json:"name"`
+
+	// Revision to start the branch at, the head of main if left out.
+	Revision int `json:"revision"`
+}
+
+func (h BranchCreateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pid, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	if _, ok := mustFetchProject(w, r, h.Deps, pid); !ok {
+		return
+	}
+
+	if !mustHaveRole(w, r, h.Deps, pid, project.RoleEditor) {
+		return
+	}
+
+	req := branchCreateReq{}
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil {
+		respondError(w, r, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	err := h.Deps.ProjectStore.CreateBranch(pid, req.Name, req.Revision)
+	if err != nil {
+		switch err {
+		case project.ErrInvalidRefName:
+			respondError(w, r, http.StatusBadRequest, "invalid branch name")
+		case project.ErrAlreadyExists:
+			respondError(w, r, http.StatusConflict, "branch already exists")
+		case project.ErrNoFound:
+			respondError(w, r, http.StatusBadRequest, "invalid revision")
+		default:
+			h.LogErr.Println(err)
+			respond500(w, r)
+		}
+		return
+	}
+
+	respondSuccess(w, r, "success", time.Time{})
+}
+
+type BranchDeleteHandler struct {
+	Deps
+}
+
+func (h BranchDeleteHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	pid, _ := strconv.Atoi(vars["id"])
+
+	if _, ok := mustFetchProject(w, r, h.Deps, pid); !ok {
+		return
+	}
+
+	if !mustHaveRole(w, r, h.Deps, pid, project.RoleEditor) {
+		return
+	}
+
+	err := h.Deps.ProjectStore.DeleteBranch(pid, vars["branch"])
+	if err != nil {
+		switch err {
+		case project.ErrMainBranch:
+			respondError(w, r, http.StatusBadRequest, "cannot delete the main branch")
+		case project.ErrNoFound:
+			respond404(w, r)
+		default:
+			h.LogErr.Println(err)
+			respond500(w, r)
+		}
+		return
+	}
+
+	respondSuccess(w, r, "success", time.Time{})
+}
@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/frengine/server/collab"
+	"github.com/frengine/server/project"
+	"github.com/gorilla/mux"
+	"github.com/gorilla/websocket"
+)
+
+const (
+	collabWriteWait  = 10 * time.Second
+	collabPongWait   = 60 * time.Second
+	collabPingPeriod = collabPongWait * 9 / 10
+
+	collabMaxMessageSize = 1 << 20
+)
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  4096,
+	WriteBufferSize: 4096,
+}
+
+// CollabHandler lets editors work on a project together over a WebSocket. See
+// collab.Message for the protocol.
+type CollabHandler struct {
+	Deps
+	Hub *collab.Hub
+}
+
+func (h CollabHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pid, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	p, ok := mustFetchProject(w, r, h.Deps, pid)
+	if !ok {
+		return
+	}
+
+	if !mustHaveRole(w, r, h.Deps, pid, project.RoleEditor) {
+		return
+	}
+
+	u, err := getUserFromVars(r)
+	if err != nil {
+		h.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	if !mustHoldLease(w, r, p, u) {
+		return
+	}
+
+	up := upgrader
+	up.CheckOrigin = h.checkOrigin
+
+	conn, err := up.Upgrade(w, r, nil)
+	if err != nil {
+		// The upgrader already responded.
+		h.LogErr.Println(err)
+		return
+	}
+
+	session, client, err := h.Hub.Join(pid, u)
+	if err != nil {
+		h.LogErr.Println(err)
+		conn.Close()
+		return
+	}
+
+	go h.writeLoop(conn, client)
+
+	defer h.Hub.Leave(session, client)
+
+	conn.SetReadLimit(collabMaxMessageSize)
+	conn.SetReadDeadline(time.Now().Add(collabPongWait))
+	conn.SetPongHandler(func(string) error {
+		return conn.SetReadDeadline(time.Now().Add(collabPongWait))
+	})
+
+	for {
+		msg := collab.Message{}
+
+		err := conn.ReadJSON(&msg)
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseGoingAway, websocket.CloseNormalClosure) {
+				h.LogErr.Println(err)
+			}
+			return
+		}
+
+		if msg.Type != "op" || msg.Op == nil {
+			continue
+		}
+
+		err = session.Receive(client, msg.Revision, *msg.Op)
+		if err != nil {
+			session.SendError(client, err)
+		}
+	}
+}
+
+// checkOrigin only lets pages of the server itself and of the configured
+// origins open a WebSocket. Clients that aren't browsers don't send an origin.
+func (h CollabHandler) checkOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return true
+	}
+
+	u, err := url.Parse(origin)
+	if err == nil && strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+
+	for _, allowed := range h.Cfg.AllowedOrigins {
+		if strings.EqualFold(strings.TrimSuffix(allowed, "/"), origin) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// writeLoop sends the messages for the client, and pings to keep the
+// connection alive. It closes the connection when the client is removed from
+// the session.
+func (h CollabHandler) writeLoop(conn *websocket.Conn, client *collab.Client) {
+	ticker := time.NewTicker(collabPingPeriod)
+	defer func() {
+		ticker.Stop()
+		conn.Close()
+	}()
+
+	for {
+		select {
+		case msg, ok := <-client.Send:
+			conn.SetWriteDeadline(time.Now().Add(collabWriteWait))
+			if !ok {
+				conn.WriteMessage(websocket.CloseMessage, []byte{})
+				return
+			}
+
+			if err := conn.WriteJSON(msg); err != nil {
+				return
+			}
+		case <-ticker.C:
+			conn.SetWriteDeadline(time.Now().Add(collabWriteWait))
+			if err := conn.WriteMessage(websocket.PingMessage, nil); err != nil {
+				return
+			}
+		}
+	}
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/frengine/server/auth"
 	"github.com/frengine/server/config"
+	"github.com/frengine/server/org"
 	"github.com/frengine/server/project"
 	"github.com/gorilla/mux"
 )
@@ -17,6 +18,7 @@ import (
 type Deps struct {
 	UserStore    auth.Store
 	ProjectStore project.Store
+	OrgStore     org.Store
 	LogInfo      *log.Logger
 	LogErr       *log.Logger
 	Cfg          config.Config
@@ -83,7 +85,20 @@ func getUserFromVars(r *http.Request) (auth.User, error) {
 	return auth.User{ID: uint(uid)}, nil
 }
 
-func mustBeLoggedInAs(w http.ResponseWriter, r *http.Request, d Deps, uid uint) bool {
+// viewerFromVars returns the ID of the logged in user, or 0 if the request is
+// anonymous.
+func viewerFromVars(r *http.Request) uint {
+	u, err := getUserFromVars(r)
+	if err != nil {
+		return 0
+	}
+
+	return u.ID
+}
+
+// mustHaveRole makes sure the logged in user has at least the role in the
+// project.
+func mustHaveRole(w http.ResponseWriter, r *http.Request, d Deps, pid int, min project.Role) bool {
 	u, err := getUserFromVars(r)
 	if err != nil {
 		d.LogErr.Println(err)
@@ -91,7 +106,18 @@ func mustBeLoggedInAs(w http.ResponseWriter, r *http.Request, d Deps, uid uint)
 		return false
 	}
 
-	if uid != u.ID {
+	role, err := d.ProjectStore.FetchRole(pid, u.ID)
+	if err != nil {
+		if err == project.ErrNoFound {
+			respond404(w, r)
+			return false
+		}
+		d.LogErr.Println(err)
+		respond500(w, r)
+		return false
+	}
+
+	if !role.AtLeast(min) {
 		respondError(w, r, http.StatusForbidden, "forbidden")
 		return false
 	}
@@ -100,7 +126,7 @@ func mustBeLoggedInAs(w http.ResponseWriter, r *http.Request, d Deps, uid uint)
 }
 
 func mustFetchProject(w http.ResponseWriter, r *http.Request, d Deps, pid int) (*project.Project, bool) {
-	p, err := d.ProjectStore.FetchByID(pid)
+	p, err := d.ProjectStore.FetchByID(pid, viewerFromVars(r))
 	if err != nil {
 		if err == project.ErrNoFound {
 			respond404(w, r)
@@ -113,3 +139,18 @@ func mustFetchProject(w http.ResponseWriter, r *http.Request, d Deps, pid int) (
 
 	return &p, true
 }
+
+func mustFetchRevision(w http.ResponseWriter, r *http.Request, d Deps, pid int, rid int) (*project.Revision, bool) {
+	rev, err := d.ProjectStore.FetchRevision(pid, rid)
+	if err != nil {
+		if err == project.ErrNoFound {
+			respond404(w, r)
+			return nil, false
+		}
+		d.LogErr.Println(err)
+		respond500(w, r)
+		return nil, false
+	}
+
+	return &rev, true
+}
@@ -0,0 +1,80 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/frengine/server/diff"
+	"github.com/gorilla/mux"
+)
+
+const defaultDiffContext = 3
+
+type RevisionDiffHandler struct {
+	Deps
+}
+
+type diffResponse struct {
+	From    int         `json:"from"`
+	To      int         `json:"to"`
+	Hunks   []diff.Hunk `json:"hunks"`
+	Unified string      `json:"unified"`
+}
+
+func (h RevisionDiffHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	pid, _ := strconv.Atoi(vars["id"])
+	aid, _ := strconv.Atoi(vars["a"])
+	bid, _ := strconv.Atoi(vars["b"])
+
+	if _, ok := mustFetchProject(w, r, h.Deps, pid); !ok {
+		return
+	}
+
+	a, ok := mustFetchRevision(w, r, h.Deps, pid, aid)
+	if !ok {
+		return
+	}
+	b, ok := mustFetchRevision(w, r, h.Deps, pid, bid)
+	if !ok {
+		return
+	}
+
+	context := defaultDiffContext
+	if c, err := strconv.Atoi(r.URL.Query().Get("context")); err == nil && c >= 0 {
+		context = c
+	}
+
+	var aContent, bContent string
+	if a.Content != nil {
+		aContent = *a.Content
+	}
+	if b.Content != nil {
+		bContent = *b.Content
+	}
+
+	hunks := diff.MakeHunks(diff.Strings(aContent, bContent), context)
+	unified := diff.Unified(fmt.Sprintf("revision/%d", aid), fmt.Sprintf("revision/%d", bid), hunks)
+
+	lm := time.Time{}
+	if a.Created != nil && a.Created.After(lm) {
+		lm = *a.Created
+	}
+	if b.Created != nil && b.Created.After(lm) {
+		lm = *b.Created
+	}
+
+	if r.URL.Query().Get("format") == "unified" {
+		w.Header().Set("Content-Type", "text/x-diff; charset=utf-8")
+		if lm != (time.Time{}) {
+			w.Header().Set("Last-Modified", lm.Format(http.TimeFormat))
+		}
+		w.WriteHeader(http.StatusOK)
+		w.Write([]byte(unified))
+		return
+	}
+
+	respondSuccess(w, r, diffResponse{aid, bid, hunks, unified}, lm)
+}
@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/frengine/server/events"
+	"github.com/gorilla/mux"
+)
+
+const eventKeepAlive = 30 * time.Second
+
+// EventStreamHandler sends the changes to a project as Server-Sent Events.
+// Clients that reconnect with Last-Event-ID get the events they missed.
+type EventStreamHandler struct {
+	Deps
+	Bus *events.Bus
+}
+
+func (h EventStreamHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pid, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	if _, ok := mustFetchProject(w, r, h.Deps, pid); !ok {
+		return
+	}
+
+	rc := http.NewResponseController(w)
+
+	// The stream stays open much longer than the server's write timeout.
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		h.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	lastID, _ := strconv.ParseInt(r.Header.Get("Last-Event-ID"), 10, 64)
+
+	// Subscribe before catching up, so nothing gets lost in between.
+	sub := h.Bus.Subscribe(pid)
+	defer h.Bus.Unsubscribe(sub)
+
+	missed := []events.Event{}
+	if lastID > 0 {
+		var err error
+		missed, err = h.Deps.ProjectStore.FetchEventsSince(pid, lastID)
+		if err != nil {
+			h.LogErr.Println(err)
+			respond500(w, r)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+
+	// Events can come in twice, after catching up or when the server lost
+	// its connection to the database. Their IDs only go up, so those up to
+	// the last one sent are old.
+	for _, e := range missed {
+		if e.ID <= lastID {
+			continue
+		}
+		if err := writeEvent(w, e); err != nil {
+			return
+		}
+		lastID = e.ID
+	}
+	rc.Flush()
+
+	keepAlive := time.NewTicker(eventKeepAlive)
+	defer keepAlive.Stop()
+
+	for {
+		select {
+		case e, ok := <-sub.C:
+			if !ok {
+				// Couldn't keep up. The client will reconnect and catch up.
+				return
+			}
+			if e.ID <= lastID {
+				continue
+			}
+
+			if err := writeEvent(w, e); err != nil {
+				return
+			}
+			lastID = e.ID
+		case <-keepAlive.C:
+			if _, err := fmt.Fprint(w, ": keep-alive\n\n"); err != nil {
+				return
+			}
+		case <-r.Context().Done():
+			return
+		}
+
+		rc.Flush()
+	}
+}
+
+func writeEvent(w http.ResponseWriter, e events.Event) error {
+	data, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	_, err = fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.ID, e.Type, data)
+	return err
+}
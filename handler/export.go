@@ -0,0 +1,79 @@
+package handler
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/frengine/server/export"
+	"github.com/frengine/server/project"
+	"github.com/gorilla/mux"
+)
+
+// exportWriteTimeout is how long an export may take to stream, which is
+// longer than the server's write timeout for big projects.
+const exportWriteTimeout = 10 * time.Minute
+
+// ExportHandler streams a project with its whole history as a tar.gz, see
+// package export for the format.
+type ExportHandler struct {
+	Deps
+}
+
+func (h ExportHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pid, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	p, ok := mustFetchProject(w, r, h.Deps, pid)
+	if !ok {
+		return
+	}
+
+	branches, err := h.Deps.ProjectStore.FetchBranches(pid)
+	if err != nil {
+		h.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	tags, err := h.Deps.ProjectStore.FetchTags(pid)
+	if err != nil {
+		h.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	members, err := h.Deps.ProjectStore.FetchMembers(pid)
+	if err != nil {
+		h.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	if err := http.NewResponseController(w).SetWriteDeadline(time.Now().Add(exportWriteTimeout)); err != nil {
+		h.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/gzip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="project-%d.tar.gz"`, pid))
+	w.WriteHeader(http.StatusOK)
+
+	// Once streaming, errors can't be responded anymore. The archive ends up
+	// truncated, which the client notices.
+	ew := export.NewWriter(w)
+
+	err = ew.WriteManifest(export.Manifest{Project: *p, Branches: branches, Tags: tags, Members: members})
+	if err == nil {
+		err = h.Deps.ProjectStore.EachRevision(pid, func(rev project.Revision) error {
+			return ew.WriteRevision(rev)
+		})
+	}
+	if err == nil {
+		err = ew.Close()
+	}
+	if err != nil {
+		h.LogErr.Println(err)
+	}
+}
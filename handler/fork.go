@@ -0,0 +1,82 @@
+package handler
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/frengine/server/project"
+	"github.com/gorilla/mux"
+)
+
+type ProjectForkHandler struct {
+	Deps
+}
+
+type forkReq struct {
+	// Name of the fork, the name of the original if left out.
+	Name string `json:"name"`
+}
+
+// ServeHTTP forks a project the user can see to a new project of theirs. The
+// body is optional.
+func (h ProjectForkHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pid, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	if _, ok := mustFetchProject(w, r, h.Deps, pid); !ok {
+		return
+	}
+
+	u, err := getUserFromVars(r)
+	if err != nil {
+		h.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	req := forkReq{}
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil && err != io.EOF {
+		respondError(w, r, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	fid, err := h.Deps.ProjectStore.Fork(pid, req.Name, u)
+	if err != nil {
+		if err == project.ErrNoFound || err == project.ErrInvalidProject {
+			respond404(w, r)
+			return
+		}
+		h.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	respondSuccess(w, r, createResponse{fid}, time.Time{})
+}
+
+// ForkListHandler lists the forks of a project. It takes the same query
+// parameters as ProjectListHandler.
+type ForkListHandler struct {
+	Deps
+}
+
+func (h ForkListHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pid, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	if _, ok := mustFetchProject(w, r, h.Deps, pid); !ok {
+		return
+	}
+
+	opts, err := searchOptionsFromRequest(r)
+	if err != nil {
+		respondError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	opts.Upstream = pid
+
+	respondProjects(w, r, h.Deps, opts)
+}
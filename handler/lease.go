@@ -0,0 +1,161 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/frengine/server/auth"
+	"github.com/frengine/server/project"
+	"github.com/gorilla/mux"
+)
+
+const (
+	defaultLeaseDuration = 5 * time.Minute
+	maxLeaseDuration     = time.Hour
+)
+
+type leaseHeldResponse struct {
+	Error string         `json:"error"`
+	Lease *project.Lease `json:"lease"`
+}
+
+// leaseDuration reads the duration in seconds from the query, which is
+// optional and capped.
+func leaseDuration(r *http.Request) time.Duration {
+	seconds, _ := strconv.Atoi(r.URL.Query().Get("duration"))
+	if seconds <= 0 {
+		return defaultLeaseDuration
+	}
+
+	d := time.Duration(seconds) * time.Second
+	if d > maxLeaseDuration {
+		return maxLeaseDuration
+	}
+
+	return d
+}
+
+// mustHoldLease makes sure nobody but u holds a lease on the project, so u
+// may save revisions.
+func mustHoldLease(w http.ResponseWriter, r *http.Request, p *project.Project, u auth.User) bool {
+	if p.Lease == nil || p.Lease.Holder.ID == u.ID {
+		return true
+	}
+
+	respondJSON(w, r, http.StatusLocked, leaseHeldResponse{"project is leased by someone else", p.Lease}, time.Time{})
+	return false
+}
+
+// respondLeaseHeld responds to a save that failed with project.ErrLeaseHeld,
+// telling who holds the lease.
+func respondLeaseHeld(w http.ResponseWriter, r *http.Request, d Deps, pid int) {
+	p, ok := mustFetchProject(w, r, d, pid)
+	if !ok {
+		return
+	}
+
+	respondJSON(w, r, http.StatusLocked, leaseHeldResponse{"project is leased by someone else", p.Lease}, time.Time{})
+}
+
+// LeaseAcquireHandler checks the project out for the user, or renews the
+// lease if they already hold it.
+type LeaseAcquireHandler struct {
+	Deps
+}
+
+func (h LeaseAcquireHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pid, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	if _, ok := mustFetchProject(w, r, h.Deps, pid); !ok {
+		return
+	}
+
+	if !mustHaveRole(w, r, h.Deps, pid, project.RoleEditor) {
+		return
+	}
+
+	u, err := getUserFromVars(r)
+	if err != nil {
+		h.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	l, err := h.Deps.ProjectStore.AcquireLease(pid, u, leaseDuration(r))
+	if err != nil {
+		switch err {
+		case project.ErrLeaseHeld:
+			// Fetch it again, the lease might have changed hands.
+			p, ok := mustFetchProject(w, r, h.Deps, pid)
+			if !ok {
+				return
+			}
+			respondJSON(w, r, http.StatusLocked, leaseHeldResponse{err.Error(), p.Lease}, time.Time{})
+		case project.ErrInvalidProject:
+			respond404(w, r)
+		default:
+			h.LogErr.Println(err)
+			respond500(w, r)
+		}
+		return
+	}
+
+	respondSuccess(w, r, l, time.Time{})
+}
+
+type LeaseRenewHandler struct {
+	Deps
+}
+
+func (h LeaseRenewHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pid, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	u, err := getUserFromVars(r)
+	if err != nil {
+		h.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	l, err := h.Deps.ProjectStore.RenewLease(pid, u, leaseDuration(r))
+	if err != nil {
+		if err == project.ErrNoFound {
+			respondError(w, r, http.StatusConflict, "lease not held")
+			return
+		}
+		h.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	respondSuccess(w, r, l, time.Time{})
+}
+
+type LeaseReleaseHandler struct {
+	Deps
+}
+
+func (h LeaseReleaseHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pid, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	u, err := getUserFromVars(r)
+	if err != nil {
+		h.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	err = h.Deps.ProjectStore.ReleaseLease(pid, u)
+	if err != nil {
+		if err == project.ErrNoFound {
+			respondError(w, r, http.StatusConflict, "lease not held")
+			return
+		}
+		h.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	respondSuccess(w, r, "success", time.Time{})
+}
@@ -0,0 +1,180 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/frengine/server/project"
+	"github.com/gorilla/mux"
+)
+
+type MemberListHandler struct {
+	Deps
+}
+
+func (h MemberListHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pid, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	if _, ok := mustFetchProject(w, r, h.Deps, pid); !ok {
+		return
+	}
+
+	ms, err := h.Deps.ProjectStore.FetchMembers(pid)
+	if err != nil {
+		h.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	respondSuccess(w, r, ms, time.Time{})
+}
+
+type MemberAddHandler struct {
+	Deps
+}
+
+type memberReq struct {
+	User uint         `json:"user"`
+	Role project.Role `json:"role"`
+}
+
+func (h MemberAddHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pid, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	p, ok := mustFetchProject(w, r, h.Deps, pid)
+	if !ok {
+		return
+	}
+
+	if !mustHaveRole(w, r, h.Deps, pid, project.RoleOwner) {
+		return
+	}
+
+	req := memberReq{}
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil {
+		respondError(w, r, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	if p.AuthorOwns() && req.User == p.Author.ID {
+		respondError(w, r, http.StatusConflict, "already a member")
+		return
+	}
+
+	err := h.Deps.ProjectStore.AddMember(pid, req.User, req.Role)
+	if err != nil {
+		switch err {
+		case project.ErrInvalidRole:
+			respondError(w, r, http.StatusBadRequest, "invalid role")
+		case project.ErrInvalidUser:
+			respondError(w, r, http.StatusBadRequest, "invalid user")
+		case project.ErrAlreadyExists:
+			respondError(w, r, http.StatusConflict, "already a member")
+		default:
+			h.LogErr.Println(err)
+			respond500(w, r)
+		}
+		return
+	}
+
+	respondSuccess(w, r, "success", time.Time{})
+}
+
+type MemberUpdateHandler struct {
+	Deps
+}
+
+func (h MemberUpdateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	pid, _ := strconv.Atoi(vars["id"])
+	mid, _ := strconv.Atoi(vars["member"])
+
+	p, ok := mustFetchProject(w, r, h.Deps, pid)
+	if !ok {
+		return
+	}
+
+	if !mustHaveRole(w, r, h.Deps, pid, project.RoleOwner) {
+		return
+	}
+
+	req := memberReq{}
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil {
+		respondError(w, r, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	if p.AuthorOwns() && uint(mid) == p.Author.ID {
+		respondError(w, r, http.StatusBadRequest, "the author is always an owner")
+		return
+	}
+
+	err := h.Deps.ProjectStore.UpdateMember(pid, uint(mid), req.Role)
+	if err != nil {
+		switch err {
+		case project.ErrInvalidRole:
+			respondError(w, r, http.StatusBadRequest, "invalid role")
+		case project.ErrNoFound:
+			respond404(w, r)
+		default:
+			h.LogErr.Println(err)
+			respond500(w, r)
+		}
+		return
+	}
+
+	respondSuccess(w, r, "success", time.Time{})
+}
+
+// MemberRemoveHandler removes a member from a project. Owners can remove
+// anyone but the author owning the project, and members can remove
+// themselves.
+type MemberRemoveHandler struct {
+	Deps
+}
+
+func (h MemberRemoveHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	pid, _ := strconv.Atoi(vars["id"])
+	mid, _ := strconv.Atoi(vars["member"])
+
+	p, ok := mustFetchProject(w, r, h.Deps, pid)
+	if !ok {
+		return
+	}
+
+	u, err := getUserFromVars(r)
+	if err != nil {
+		h.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	if u.ID != uint(mid) && !mustHaveRole(w, r, h.Deps, pid, project.RoleOwner) {
+		return
+	}
+
+	if p.AuthorOwns() && uint(mid) == p.Author.ID {
+		respondError(w, r, http.StatusBadRequest, "the author is always an owner")
+		return
+	}
+
+	err = h.Deps.ProjectStore.RemoveMember(pid, uint(mid))
+	if err != nil {
+		if err == project.ErrNoFound {
+			respond404(w, r)
+			return
+		}
+		h.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	respondSuccess(w, r, "success", time.Time{})
+}
@@ -0,0 +1,371 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/frengine/server/org"
+	"github.com/frengine/server/project"
+	"github.com/gorilla/mux"
+)
+
+func mustFetchOrg(w http.ResponseWriter, r *http.Request, d Deps, oid int) (*org.Organization, bool) {
+	o, err := d.OrgStore.FetchByID(oid)
+	if err != nil {
+		if err == org.ErrNoFound {
+			respond404(w, r)
+			return nil, false
+		}
+		d.LogErr.Println(err)
+		respond500(w, r)
+		return nil, false
+	}
+
+	return &o, true
+}
+
+// mustOwnOrg makes sure the logged in user is an owner of the organization.
+func mustOwnOrg(w http.ResponseWriter, r *http.Request, d Deps, oid int) bool {
+	u, err := getUserFromVars(r)
+	if err != nil {
+		d.LogErr.Println(err)
+		respond500(w, r)
+		return false
+	}
+
+	role, err := d.OrgStore.FetchRole(oid, u.ID)
+	if err != nil {
+		d.LogErr.Println(err)
+		respond500(w, r)
+		return false
+	}
+
+	if role != org.RoleOwner {
+		respondError(w, r, http.StatusForbidden, "forbidden")
+		return false
+	}
+
+	return true
+}
+
+type OrgCreateHandler struct {
+	Deps
+}
+
+type orgCreateReq struct {
+	Name string `json:"name"`
+}
+
+type orgCreateResponse struct {
+	OrganizationID int `json:"organizationID"`
+}
+
+func (h OrgCreateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	req := orgCreateReq{}
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil {
+		respondError(w, r, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	u, err := getUserFromVars(r)
+	if err != nil {
+		h.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	oid, err := h.Deps.OrgStore.Create(req.Name, u)
+	if err != nil {
+		switch err {
+		case org.ErrInvalidName:
+			respondError(w, r, http.StatusBadRequest, "invalid name")
+		case org.ErrAlreadyExists:
+			respondError(w, r, http.StatusConflict, "organization already exists")
+		default:
+			h.LogErr.Println(err)
+			respond500(w, r)
+		}
+		return
+	}
+
+	respondSuccess(w, r, orgCreateResponse{oid}, time.Time{})
+}
+
+type OrgGetHandler struct {
+	Deps
+}
+
+func (h OrgGetHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	oid, _ := strconv.Atoi(mux.Vars(r)["org"])
+
+	o, ok := mustFetchOrg(w, r, h.Deps, oid)
+	if !ok {
+		return
+	}
+
+	respondSuccess(w, r, o, time.Time{})
+}
+
+// OrgProjectListHandler lists the projects of an organization, as far as the
+// user may see them. It takes the same query parameters as
+// ProjectListHandler.
+type OrgProjectListHandler struct {
+	Deps
+}
+
+func (h OrgProjectListHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	oid, _ := strconv.Atoi(mux.Vars(r)["org"])
+
+	if _, ok := mustFetchOrg(w, r, h.Deps, oid); !ok {
+		return
+	}
+
+	opts, err := searchOptionsFromRequest(r)
+	if err != nil {
+		respondError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	opts.Organization = oid
+
+	respondProjects(w, r, h.Deps, opts)
+}
+
+type OrgMemberListHandler struct {
+	Deps
+}
+
+func (h OrgMemberListHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	oid, _ := strconv.Atoi(mux.Vars(r)["org"])
+
+	if _, ok := mustFetchOrg(w, r, h.Deps, oid); !ok {
+		return
+	}
+
+	ms, err := h.Deps.OrgStore.FetchMembers(oid)
+	if err != nil {
+		h.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	respondSuccess(w, r, ms, time.Time{})
+}
+
+type OrgMemberAddHandler struct {
+	Deps
+}
+
+type orgMemberReq struct {
+	User uint     `json:"user"`
+	Role org.Role `json:"role"`
+}
+
+func (h OrgMemberAddHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	oid, _ := strconv.Atoi(mux.Vars(r)["org"])
+
+	if _, ok := mustFetchOrg(w, r, h.Deps, oid); !ok {
+		return
+	}
+
+	if !mustOwnOrg(w, r, h.Deps, oid) {
+		return
+	}
+
+	req := orgMemberReq{}
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil {
+		respondError(w, r, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	err := h.Deps.OrgStore.AddMember(oid, req.User, req.Role)
+	if err != nil {
+		switch err {
+		case org.ErrInvalidRole:
+			respondError(w, r, http.StatusBadRequest, "invalid role")
+		case org.ErrInvalidUser:
+			respondError(w, r, http.StatusBadRequest, "invalid user")
+		case org.ErrAlreadyExists:
+			respondError(w, r, http.StatusConflict, "already a member")
+		default:
+			h.LogErr.Println(err)
+			respond500(w, r)
+		}
+		return
+	}
+
+	respondSuccess(w, r, "success", time.Time{})
+}
+
+type OrgMemberUpdateHandler struct {
+	Deps
+}
+
+func (h OrgMemberUpdateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	oid, _ := strconv.Atoi(vars["org"])
+	mid, _ := strconv.Atoi(vars["member"])
+
+	if _, ok := mustFetchOrg(w, r, h.Deps, oid); !ok {
+		return
+	}
+
+	if !mustOwnOrg(w, r, h.Deps, oid) {
+		return
+	}
+
+	req := orgMemberReq{}
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil {
+		respondError(w, r, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	err := h.Deps.OrgStore.UpdateMember(oid, uint(mid), req.Role)
+	if err != nil {
+		switch err {
+		case org.ErrInvalidRole:
+			respondError(w, r, http.StatusBadRequest, "invalid role")
+		case org.ErrLastOwner:
+			respondError(w, r, http.StatusConflict, "an organization needs an owner")
+		case org.ErrNoFound:
+			respond404(w, r)
+		default:
+			h.LogErr.Println(err)
+			respond500(w, r)
+		}
+		return
+	}
+
+	respondSuccess(w, r, "success", time.Time{})
+}
+
+// OrgMemberRemoveHandler removes a member from an organization. Owners can
+// remove anyone, and members can remove themselves, as long as an owner
+// remains.
+type OrgMemberRemoveHandler struct {
+	Deps
+}
+
+func (h OrgMemberRemoveHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	oid, _ := strconv.Atoi(vars["org"])
+	mid, _ := strconv.Atoi(vars["member"])
+
+	if _, ok := mustFetchOrg(w, r, h.Deps, oid); !ok {
+		return
+	}
+
+	u, err := getUserFromVars(r)
+	if err != nil {
+		h.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	if u.ID != uint(mid) && !mustOwnOrg(w, r, h.Deps, oid) {
+		return
+	}
+
+	err = h.Deps.OrgStore.RemoveMember(oid, uint(mid))
+	if err != nil {
+		switch err {
+		case org.ErrLastOwner:
+			respondError(w, r, http.StatusConflict, "an organization needs an owner")
+		case org.ErrNoFound:
+			respond404(w, r)
+		default:
+			h.LogErr.Println(err)
+			respond500(w, r)
+		}
+		return
+	}
+
+	respondSuccess(w, r, "success", time.Time{})
+}
+
+// ProjectMoveHandler moves a project of the author into an organization they
+// are a member of, or with organization 0 out of its organization. Only an
+// owner of the organization can take a project out, and becomes its author.
+type ProjectMoveHandler struct {
+	Deps
+}
+
+type moveReq struct {
+	Organization int `json:"organization"`
+}
+
+func (h ProjectMoveHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pid, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	p, ok := mustFetchProject(w, r, h.Deps, pid)
+	if !ok {
+		return
+	}
+
+	req := moveReq{}
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil {
+		respondError(w, r, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	u, err := getUserFromVars(r)
+	if err != nil {
+		h.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	if req.Organization == 0 {
+		if p.AuthorOwns() {
+			respondError(w, r, http.StatusBadRequest, "the project isn't in an organization")
+			return
+		}
+
+		if !mustOwnOrg(w, r, h.Deps, p.Organization.ID) {
+			return
+		}
+	} else {
+		// Owners who are only members of the project can't give it away.
+		if !p.AuthorOwns() || u.ID != p.Author.ID {
+			respondError(w, r, http.StatusForbidden, "forbidden")
+			return
+		}
+
+		if _, ok := mustFetchOrg(w, r, h.Deps, req.Organization); !ok {
+			return
+		}
+
+		role, err := h.Deps.OrgStore.FetchRole(req.Organization, u.ID)
+		if err != nil {
+			h.LogErr.Println(err)
+			respond500(w, r)
+			return
+		}
+		if role == "" {
+			respondError(w, r, http.StatusForbidden, "forbidden")
+			return
+		}
+	}
+
+	err = h.Deps.ProjectStore.Move(pid, req.Organization, u)
+	if err != nil {
+		if err == project.ErrNoFound {
+			respond404(w, r)
+			return
+		}
+		h.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	respondSuccess(w, r, "success", time.Time{})
+}
@@ -0,0 +1,76 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/frengine/server/auth"
+	"github.com/frengine/server/org"
+	"github.com/frengine/server/project"
+)
+
+const (
+	testAuthor   = 1
+	testOwner    = 2
+	testOrgOwner = 3
+	testOrg      = 5
+)
+
+func moveFixture(inOrg bool) (*fakeProjects, *fakeOrgs) {
+	projects := &fakeProjects{
+		p:     project.Project{ID: 1, Author: &auth.User{ID: testAuthor}},
+		roles: map[uint]project.Role{testOwner: project.RoleOwner},
+	}
+	if inOrg {
+		projects.p.Organization = &org.Organization{ID: testOrg}
+	}
+
+	orgs := &fakeOrgs{
+		o: org.Organization{ID: testOrg},
+		roles: map[uint]org.Role{
+			testAuthor:   org.RoleMember,
+			testOwner:    org.RoleMember,
+			testOrgOwner: org.RoleOwner,
+		},
+	}
+
+	return projects, orgs
+}
+
+func TestProjectMove(t *testing.T) {
+	tests := []struct {
+		name  string
+		inOrg bool
+		user  uint
+		body  string
+
+		code     int
+		movedTo  uint
+		movedOrg int
+	}{
+		{"author moves into org", false, testAuthor, `{"organization": 5}`, http.StatusOK, testAuthor, testOrg},
+		{"member owner moves into org", false, testOwner, `{"organization": 5}`, http.StatusForbidden, 0, 0},
+		{"org owner moves out", true, testOrgOwner, `{"organization": 0}`, http.StatusOK, testOrgOwner, 0},
+		{"member owner moves out", true, testOwner, `{"organization": 0}`, http.StatusForbidden, 0, 0},
+		{"author moves out", true, testAuthor, `{"organization": 0}`, http.StatusForbidden, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			projects, orgs := moveFixture(tt.inOrg)
+			h := ProjectMoveHandler{fakeDeps(projects, orgs)}
+
+			w := serve(t, h, tt.user, map[string]string{"id": "1"}, tt.body)
+
+			if w.Code != tt.code {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.code, w.Body)
+			}
+			if projects.moved != (tt.code == http.StatusOK) {
+				t.Fatalf("moved is %v", projects.moved)
+			}
+			if projects.moved && (projects.movedTo != tt.movedTo || projects.movedOrg != tt.movedOrg) {
+				t.Errorf("moved to org %d with author %d, want org %d with author %d", projects.movedOrg, projects.movedTo, tt.movedOrg, tt.movedTo)
+			}
+		})
+	}
+}
@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"mime"
+	"net/http"
+	"strconv"
+
+	"github.com/frengine/server/diff"
+	"github.com/frengine/server/project"
+	"github.com/gorilla/mux"
+)
+
+type RevisionPatchHandler struct {
+	Deps
+}
+
+type patchReq struct {
+	Edits []diff.Edit `json:"edits"`
+}
+
+// ServeHTTP saves a new revision by patching the base revision, which the
+// client names like for RevisionSaveHandler. The body is either a unified
+// diff, or JSON with a list of edits when the Content-Type says so.
+func (h RevisionPatchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pid, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	if _, ok := mustFetchProject(w, r, h.Deps, pid); !ok {
+		return
+	}
+
+	if !mustHaveRole(w, r, h.Deps, pid, project.RoleEditor) {
+		return
+	}
+
+	u, err := getUserFromVars(r)
+	if err != nil {
+		h.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	base, err := baseFromRequest(r)
+	if err != nil {
+		respondError(w, r, http.StatusBadRequest, "invalid parent revision")
+		return
+	}
+	if base.parent == nil && base.hash == "" {
+		respondError(w, r, http.StatusBadRequest, "parent revision required")
+		return
+	}
+
+	baseContent, err := fetchBaseContent(h.Deps, pid, base)
+	if err != nil {
+		if err == project.ErrNoFound {
+			respondError(w, r, http.StatusBadRequest, "invalid parent revision")
+			return
+		}
+		h.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	var content string
+	var applyErr error
+
+	mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if mediaType == "application/json" {
+		req := patchReq{}
+
+		decoder := json.NewDecoder(r.Body)
+		if err := decoder.Decode(&req); err != nil {
+			respondError(w, r, http.StatusBadRequest, "invalid json")
+			return
+		}
+
+		content, applyErr = diff.ApplyEdits(baseContent, req.Edits)
+	} else {
+		patch, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			h.LogErr.Println(err)
+			respond500(w, r)
+			return
+		}
+
+		hunks, err := diff.Parse(string(patch))
+		if err != nil {
+			respondError(w, r, http.StatusBadRequest, "invalid patch")
+			return
+		}
+
+		content, applyErr = diff.Apply(baseContent, hunks)
+	}
+	if applyErr != nil {
+		respondError(w, r, http.StatusUnprocessableEntity, "patch does not apply")
+		return
+	}
+
+	saveAndRespond(w, r, h.Deps, pid, u, base, content)
+}
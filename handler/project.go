@@ -8,23 +8,115 @@ import (
 	"time"
 
 	"github.com/frengine/server/auth"
+	"github.com/frengine/server/org"
 	"github.com/frengine/server/project"
 	"github.com/gorilla/mux"
 )
 
+const (
+	defaultProjectLimit = 50
+	maxProjectLimit     = 200
+)
+
+// ProjectListHandler lists the projects the user may see. They can be
+// filtered with the query parameters q (part of the name), author and since
+// (touched since, in Unix time), and sorted with sort (touched, name, created
+// or modified) and order (asc or desc). Projects are listed in pages of limit,
+// with a Link header pointing at the next page.
 type ProjectListHandler struct {
 	Deps
 }
 
 func (h ProjectListHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
-	ps, err := h.Deps.ProjectStore.Search()
-	if err != nil && err != project.ErrNoFound {
-		h.LogErr.Println(err)
+	opts, err := searchOptionsFromRequest(r)
+	if err != nil {
+		respondError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	respondProjects(w, r, h.Deps, opts)
+}
+
+func searchOptionsFromRequest(r *http.Request) (project.SearchOptions, error) {
+	q := r.URL.Query()
+
+	opts := project.SearchOptions{
+		Viewer: viewerFromVars(r),
+		Query:  q.Get("q"),
+		Sort:   q.Get("sort"),
+		Cursor: q.Get("cursor"),
+
+		WithContent: q.Get("content") == "1" || q.Get("content") == "true",
+	}
+
+	if opts.Sort == "" {
+		opts.Sort = project.SortTouched
+	}
+	if !project.ValidSort(opts.Sort) {
+		return opts, fmt.Errorf("invalid sort")
+	}
+
+	// Names read best from A to Z, everything else newest first.
+	switch q.Get("order") {
+	case "":
+		opts.Asc = opts.Sort == project.SortName
+	case "asc":
+		opts.Asc = true
+	case "desc":
+		opts.Asc = false
+	default:
+		return opts, fmt.Errorf("invalid order")
+	}
+
+	if author := q.Get("author"); author != "" {
+		id, err := strconv.ParseUint(author, 10, 0)
+		if err != nil {
+			return opts, fmt.Errorf("invalid author")
+		}
+		opts.Author = uint(id)
+	}
+
+	if since := q.Get("since"); since != "" {
+		uts, err := strconv.ParseInt(since, 10, 64)
+		if err != nil {
+			return opts, fmt.Errorf("invalid since")
+		}
+		opts.TouchedSince = time.Unix(uts, 0)
+	}
+
+	opts.Limit, _ = strconv.Atoi(q.Get("limit"))
+	if opts.Limit <= 0 {
+		opts.Limit = defaultProjectLimit
+	}
+	if opts.Limit > maxProjectLimit {
+		opts.Limit = maxProjectLimit
+	}
+
+	return opts, nil
+}
+
+// respondProjects responds with a page of the projects found, with a Link
+// header to the next page if there is one.
+func respondProjects(w http.ResponseWriter, r *http.Request, d Deps, opts project.SearchOptions) {
+	ps, next, err := d.ProjectStore.Search(opts)
+	if err != nil {
+		if err == project.ErrInvalidCursor {
+			respondError(w, r, http.StatusBadRequest, "invalid cursor")
+			return
+		}
+		d.LogErr.Println(err)
 		respond500(w, r)
 		return
 	}
 
-	// TODO: Last modified
+	if next != "" {
+		u := *r.URL
+		q := u.Query()
+		q.Set("cursor", next)
+		u.RawQuery = q.Encode()
+
+		w.Header().Set("Link", "<"+u.RequestURI()+`>; rel="next"`)
+	}
 
 	respondSuccess(w, r, ps, time.Time{})
 }
@@ -53,8 +145,17 @@ type createResponse struct {
 }
 
 type createReq struct {
-	Name   string `json:"name"`
-	Author uint   `json:"author"`
+	Name       string `json:"name"`
+	Author     uint   `json:"author"`
+	Visibility string `json:"visibility"`
+
+	// Organization to create the project for, which the user has to be a
+	// member of.
+	Organization int `json:"organization"`
+
+	// Template is the ID of a template project to start from, which the
+	// first revision is a copy of.
+	Template int `json:"template"`
 }
 
 func (h ProjectCreateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -82,7 +183,51 @@ func (h ProjectCreateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	pid, err := h.Deps.ProjectStore.Create(req.Name, auth.User{ID: req.Author})
+	if req.Visibility != "" && !project.ValidVisibility(req.Visibility) {
+		respondError(w, r, http.StatusBadRequest, "invalid visibility")
+		return
+	}
+
+	p := project.Project{Name: req.Name, Author: &auth.User{ID: req.Author}, Visibility: req.Visibility}
+
+	if req.Organization != 0 {
+		if _, ok := mustFetchOrg(w, r, h.Deps, req.Organization); !ok {
+			return
+		}
+
+		role, err := h.Deps.OrgStore.FetchRole(req.Organization, u.ID)
+		if err != nil {
+			h.LogErr.Println(err)
+			respond500(w, r)
+			return
+		}
+		if role == "" {
+			respondError(w, r, http.StatusForbidden, "forbidden")
+			return
+		}
+
+		p.Organization = &org.Organization{ID: req.Organization}
+	}
+
+	if req.Template != 0 {
+		t, err := h.Deps.ProjectStore.FetchByID(req.Template, u.ID)
+		if err != nil && err != project.ErrNoFound {
+			h.LogErr.Println(err)
+			respond500(w, r)
+			return
+		}
+		if err == project.ErrNoFound || !t.Template {
+			respondError(w, r, http.StatusBadRequest, "invalid template")
+			return
+		}
+
+		if t.Revision != nil && t.Revision.Content != nil {
+			message := fmt.Sprintf("Create from template %s", t.Name)
+			p.Revision = &project.Revision{Message: &message, Content: t.Revision.Content}
+		}
+	}
+
+	pid, err := h.Deps.ProjectStore.Create(p)
 	if err != nil {
 		h.LogErr.Println(err)
 		respond500(w, r)
@@ -97,8 +242,12 @@ type ProjectUpdateHandler struct {
 }
 
 type updateReq struct {
-	Name   string `json:"name"`
-	Author uint   `json:"author"`
+	Name       string `json:"name"`
+	Visibility string `json:"visibility"`
+	Template   *bool  `json:"template"`
+
+	// Author can't be changed here, see TransferProposeHandler.
+	Author uint `json:"author"`
 }
 
 func (h ProjectUpdateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
@@ -109,7 +258,7 @@ func (h ProjectUpdateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 		return
 	}
 
-	if !mustBeLoggedInAs(w, r, h.Deps, p.Author.ID) {
+	if !mustHaveRole(w, r, h.Deps, pid, project.RoleOwner) {
 		return
 	}
 
@@ -124,16 +273,27 @@ func (h ProjectUpdateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request)
 	if req.Name != "" {
 		p.Name = req.Name
 	}
-	if req.Author > 0 {
-		p.Author.ID = req.Author
+	if req.Author > 0 && req.Author != p.Author.ID {
+		respondError(w, r, http.StatusBadRequest, "the author changes through a transfer")
+		return
+	}
+	if req.Visibility != "" {
+		if !project.ValidVisibility(req.Visibility) {
+			respondError(w, r, http.StatusBadRequest, "invalid visibility")
+			return
+		}
+		p.Visibility = req.Visibility
+	}
+	if req.Template != nil && *req.Template != p.Template {
+		if !isAdmin(h.Cfg, viewerFromVars(r)) {
+			respondError(w, r, http.StatusForbidden, "only admins pick templates")
+			return
+		}
+		p.Template = *req.Template
 	}
 
 	err := h.Deps.ProjectStore.Update(*p)
 	if err != nil {
-		if err == project.ErrInvalidAuthor {
-			respondError(w, r, http.StatusBadRequest, "invalid author")
-			return
-		}
 		h.LogErr.Println(err)
 		respond500(w, r)
 		return
@@ -149,32 +309,19 @@ type ProjectDeleteHandler struct {
 func (h ProjectDeleteHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	pid, _ := strconv.Atoi(mux.Vars(r)["id"])
 
-	p, err := h.Deps.ProjectStore.FetchByID(pid)
-	if err != nil {
-		if err == project.ErrNoFound {
-			respond404(w, r)
-			return
-		}
-		h.LogErr.Println(err)
-		respond500(w, r)
+	if _, ok := mustFetchProject(w, r, h.Deps, pid); !ok {
 		return
 	}
 
-	u, err := getUserFromVars(r)
-	if err != nil {
-		h.LogErr.Println(err)
-		respond500(w, r)
-		return
-	}
-
-	if p.Author.ID != u.ID {
-		respondError(w, r, http.StatusForbidden, "forbidden")
+	if !mustHaveRole(w, r, h.Deps, pid, project.RoleOwner) {
 		return
 	}
 
-	err = h.Deps.ProjectStore.Delete(pid)
+	err := h.Deps.ProjectStore.Delete(pid)
 	if err != nil {
-		fmt.Println(err)
+		h.LogErr.Println(err)
+		respond500(w, r)
+		return
 	}
 
 	respondSuccess(w, r, "success", time.Time{})
@@ -1,11 +1,15 @@
 package handler
 
 import (
+	"errors"
 	"io/ioutil"
 	"net/http"
 	"strconv"
+	"strings"
 	"time"
 
+	"github.com/frengine/server/auth"
+	"github.com/frengine/server/diff"
 	"github.com/frengine/server/project"
 	"github.com/gorilla/mux"
 )
@@ -17,8 +21,28 @@ type RevisionGetHandler struct {
 func (h RevisionGetHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	pid, _ := strconv.Atoi(mux.Vars(r)["id"])
 
-	rev, err := h.Deps.ProjectStore.FetchLatestRevisionByProject(pid)
+	if _, ok := mustFetchProject(w, r, h.Deps, pid); !ok {
+		return
+	}
+
+	var rev project.Revision
+	var err error
+
+	// A ref can be a branch or a tag, but the main branch is special because
+	// it exists before anything is saved onto it.
+	switch ref := r.URL.Query().Get("ref"); ref {
+	case "":
+		rev, err = fetchHead(h.Deps, pid, branchFromVars(r))
+	case project.MainBranch:
+		rev, err = fetchHead(h.Deps, pid, ref)
+	default:
+		rev, err = h.Deps.ProjectStore.FetchRevisionByRef(pid, ref)
+	}
 	if err != nil {
+		if err == project.ErrNoFound {
+			respond404(w, r)
+			return
+		}
 		h.LogErr.Println(err)
 		respond500(w, r)
 		return
@@ -29,9 +53,200 @@ func (h RevisionGetHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		lm = *rev.Created
 	}
 
+	if rev.Hash != nil {
+		w.Header().Set("ETag", revisionETag(*rev.Hash))
+	}
+
 	respondSuccess(w, r, rev, lm)
 }
 
+// branchFromVars returns the branch named in the route, or the main branch
+// for routes without one.
+func branchFromVars(r *http.Request) string {
+	if branch := mux.Vars(r)["branch"]; branch != "" {
+		return branch
+	}
+	return project.MainBranch
+}
+
+// fetchHead returns the revision the branch points at. The main branch always
+// exists, so it gives an empty revision instead of project.ErrNoFound.
+func fetchHead(d Deps, pid int, branch string) (project.Revision, error) {
+	if branch == project.MainBranch {
+		return d.ProjectStore.FetchLatestRevisionByProject(pid)
+	}
+	return d.ProjectStore.FetchBranchHead(pid, branch)
+}
+
+// revisionETag makes the ETag for revision content from its hash. Clients
+// can send it back as If-Match when saving a new revision on top of it.
+func revisionETag(hash string) string {
+	return `"` + hash + `"`
+}
+
+// saveBase is what the client based its save on: either a revision ID from
+// the parent query parameter, or a content hash from the If-Match header.
+// Both are empty if the client didn't say.
+type saveBase struct {
+	parent *int
+	hash   string
+}
+
+func baseFromRequest(r *http.Request) (saveBase, error) {
+	if s := r.URL.Query().Get("parent"); s != "" {
+		parent, err := strconv.Atoi(s)
+		if err != nil {
+			return saveBase{}, err
+		}
+		return saveBase{parent: &parent}, nil
+	}
+
+	if s := r.Header.Get("If-Match"); s != "" && s != "*" {
+		s = strings.TrimPrefix(s, "W/")
+		return saveBase{hash: strings.Trim(s, `"`)}, nil
+	}
+
+	return saveBase{}, nil
+}
+
+// unknownParent is not the ID of any revision, so saving with it as the
+// parent always conflicts.
+var unknownParent = -1
+
+// expectedParent returns the revision the branch has to point at for a save
+// on top of base to go through without merging.
+func expectedParent(d Deps, pid int, branch string, base saveBase) (*int, error) {
+	if base.hash == "" {
+		return base.parent, nil
+	}
+
+	head, err := fetchHead(d, pid, branch)
+	if err != nil {
+		return nil, err
+	}
+
+	if head.Hash != nil && *head.Hash == base.hash {
+		return head.ID, nil
+	}
+
+	return &unknownParent, nil
+}
+
+// fetchBaseContent returns the content the client based its save on.
+func fetchBaseContent(d Deps, pid int, base saveBase) (string, error) {
+	if base.hash != "" {
+		return d.ProjectStore.FetchBlob(pid, base.hash)
+	}
+
+	if base.parent == nil || *base.parent == 0 {
+		return "", nil
+	}
+
+	rev, err := d.ProjectStore.FetchRevision(pid, *base.parent)
+	if err != nil {
+		return "", err
+	}
+	if rev.Content == nil {
+		return "", nil
+	}
+
+	return *rev.Content, nil
+}
+
+type conflictResponse struct {
+	Error string           `json:"error"`
+	Head  project.Revision `json:"head"`
+
+	Merge *diff.MergeResult `json:"merge,omitempty"`
+}
+
+// respondConflict tells the client that the project moved on, and sends the
+// latest revision so it can be merged with. If the server tried merging
+// already, the result with conflict markers is sent too.
+func respondConflict(w http.ResponseWriter, r *http.Request, d Deps, pid int, branch string, merge *diff.MergeResult) {
+	head, err := fetchHead(d, pid, branch)
+	if err != nil {
+		d.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	if head.Hash != nil {
+		w.Header().Set("ETag", revisionETag(*head.Hash))
+	}
+
+	respondJSON(w, r, http.StatusConflict, conflictResponse{"conflict", head, merge}, time.Time{})
+}
+
+// errUnknownBase is returned when the client based its save on content that
+// no revision of the project has.
+var errUnknownBase = errors.New("unknown base content")
+
+// maxMergeAttempts is how often a save is merged again when the project keeps
+// moving on while merging.
+const maxMergeAttempts = 3
+
+// mergeAndSave merges content, which the client based on base, with the head
+// of the branch and saves the result if there are no conflicts. Otherwise the
+// merge result is returned with project.ErrConflict.
+func mergeAndSave(d Deps, pid int, branch string, base saveBase, author auth.User, message string, content string) (int, *diff.MergeResult, error) {
+	baseContent, err := fetchBaseContent(d, pid, base)
+	if err == project.ErrNoFound && base.hash != "" {
+		return 0, nil, errUnknownBase
+	}
+	if err == project.ErrNoFound {
+		// Nothing to merge with, the client has to sort it out.
+		return 0, nil, project.ErrConflict
+	}
+	if err != nil {
+		return 0, nil, err
+	}
+
+	for i := 0; i < maxMergeAttempts; i++ {
+		head, err := fetchHead(d, pid, branch)
+		if err != nil {
+			return 0, nil, err
+		}
+
+		headID := 0
+		headContent := ""
+		if head.ID != nil {
+			headID = *head.ID
+		}
+		if head.Content != nil {
+			headContent = *head.Content
+		}
+
+		m := diff.Merge3(baseContent, content, headContent, "yours", "revision "+strconv.Itoa(headID))
+		if !m.Clean() {
+			return 0, &m, project.ErrConflict
+		}
+
+		id, err := d.ProjectStore.SaveRevision(pid, branch, &headID, author, message, m.Content)
+		if err != project.ErrConflict {
+			return id, &m, err
+		}
+	}
+
+	return 0, nil, project.ErrConflict
+}
+
+type revisionResponse struct {
+	RevisionID int `json:"revisionID"`
+}
+
+type saveResponse struct {
+	RevisionID int `json:"revisionID"`
+
+	// Merged is set when the save was based on an older revision and the
+	// server merged it with the latest one.
+	Merged bool `json:"merged"`
+
+	// Unchanged is set when the content was the same as the latest revision
+	// and skipUnchanged was asked for, so nothing was saved.
+	Unchanged bool `json:"unchanged"`
+}
+
 type RevisionSaveHandler struct {
 	Deps
 }
@@ -39,12 +254,24 @@ type RevisionSaveHandler struct {
 func (h RevisionSaveHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	pid, _ := strconv.Atoi(mux.Vars(r)["id"])
 
-	p, ok := mustFetchProject(w, r, h.Deps, pid)
-	if !ok {
+	if _, ok := mustFetchProject(w, r, h.Deps, pid); !ok {
 		return
 	}
 
-	if !mustBeLoggedInAs(w, r, h.Deps, p.Author.ID) {
+	if !mustHaveRole(w, r, h.Deps, pid, project.RoleEditor) {
+		return
+	}
+
+	u, err := getUserFromVars(r)
+	if err != nil {
+		h.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	base, err := baseFromRequest(r)
+	if err != nil {
+		respondError(w, r, http.StatusBadRequest, "invalid parent revision")
 		return
 	}
 
@@ -55,16 +282,212 @@ func (h RevisionSaveHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	err = h.Deps.ProjectStore.SaveRevision(pid, string(content))
+	saveAndRespond(w, r, h.Deps, pid, u, base, string(content))
+}
+
+// saveAndRespond saves content as a new revision on top of base, merging it
+// if the branch moved on in the meantime, and responds with the result.
+func saveAndRespond(w http.ResponseWriter, r *http.Request, d Deps, pid int, u auth.User, base saveBase, content string) {
+	q := r.URL.Query()
+	branch := branchFromVars(r)
+	message := q.Get("message")
+	saved := content
+	merged := false
+
+	if q.Get("skipUnchanged") == "1" || q.Get("skipUnchanged") == "true" {
+		head, err := fetchHead(d, pid, branch)
+		if err != nil && err != project.ErrNoFound {
+			d.LogErr.Println(err)
+			respond500(w, r)
+			return
+		}
+
+		if head.Hash != nil && *head.Hash == project.HashContent(saved) {
+			w.Header().Set("ETag", revisionETag(*head.Hash))
+			respondSuccess(w, r, saveResponse{*head.ID, false, true}, time.Time{})
+			return
+		}
+	}
+
+	parent, err := expectedParent(d, pid, branch, base)
+	if err != nil && err != project.ErrNoFound {
+		d.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	id, err := d.ProjectStore.SaveRevision(pid, branch, parent, u, message, saved)
+	if err == project.ErrConflict {
+		var m *diff.MergeResult
+
+		id, m, err = mergeAndSave(d, pid, branch, base, u, message, saved)
+		if err == project.ErrConflict {
+			respondConflict(w, r, d, pid, branch, m)
+			return
+		}
+
+		if err == nil {
+			saved = m.Content
+			merged = true
+		}
+	}
 	if err != nil {
+		if err == project.ErrLeaseHeld {
+			respondLeaseHeld(w, r, d, pid)
+			return
+		}
+		if err == errUnknownBase {
+			respondError(w, r, http.StatusPreconditionFailed, "unknown base revision")
+			return
+		}
+		if err == project.ErrNoFound {
+			respond404(w, r)
+			return
+		}
 		if err == project.ErrInvalidProject {
 			respondError(w, r, http.StatusBadRequest, "invalid project")
 			return
 		}
+		d.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	w.Header().Set("ETag", revisionETag(project.HashContent(saved)))
+
+	respondSuccess(w, r, saveResponse{id, merged, false}, time.Time{})
+}
+
+const (
+	defaultRevisionLimit = 50
+	maxRevisionLimit     = 200
+)
+
+// RevisionListHandler lists the history of the branch in the branch query
+// parameter, or of main, newest first.
+type RevisionListHandler struct {
+	Deps
+}
+
+func (h RevisionListHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pid, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	if _, ok := mustFetchProject(w, r, h.Deps, pid); !ok {
+		return
+	}
+
+	q := r.URL.Query()
+
+	branch := q.Get("branch")
+	if branch == "" {
+		branch = project.MainBranch
+	}
+
+	if _, err := fetchHead(h.Deps, pid, branch); err != nil {
+		if err == project.ErrNoFound {
+			respond404(w, r)
+			return
+		}
+		h.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	offset, _ := strconv.Atoi(q.Get("offset"))
+	if offset < 0 {
+		offset = 0
+	}
+
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	if limit <= 0 {
+		limit = defaultRevisionLimit
+	}
+	if limit > maxRevisionLimit {
+		limit = maxRevisionLimit
+	}
+
+	withContent := q.Get("content") == "1" || q.Get("content") == "true"
+
+	revs, err := h.Deps.ProjectStore.FetchRevisionsByProject(pid, branch, offset, limit, withContent)
+	if err != nil {
+		h.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	// Newest first, so the first one is the most recent modification.
+	lm := time.Time{}
+	if len(revs) > 0 && revs[0].Created != nil {
+		lm = *revs[0].Created
+	}
+
+	respondSuccess(w, r, revs, lm)
+}
+
+type RevisionFetchHandler struct {
+	Deps
+}
+
+func (h RevisionFetchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	pid, _ := strconv.Atoi(vars["id"])
+	rid, _ := strconv.Atoi(vars["rid"])
+
+	if _, ok := mustFetchProject(w, r, h.Deps, pid); !ok {
+		return
+	}
+
+	rev, ok := mustFetchRevision(w, r, h.Deps, pid, rid)
+	if !ok {
+		return
+	}
+
+	lm := time.Time{}
+	if rev.Created != nil {
+		lm = *rev.Created
+	}
+
+	respondSuccess(w, r, rev, lm)
+}
+
+type RevisionRestoreHandler struct {
+	Deps
+}
+
+func (h RevisionRestoreHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	pid, _ := strconv.Atoi(vars["id"])
+	rid, _ := strconv.Atoi(vars["rid"])
+
+	if _, ok := mustFetchProject(w, r, h.Deps, pid); !ok {
+		return
+	}
+
+	if !mustHaveRole(w, r, h.Deps, pid, project.RoleEditor) {
+		return
+	}
+
+	u, err := getUserFromVars(r)
+	if err != nil {
+		h.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	id, err := h.Deps.ProjectStore.RestoreRevision(pid, rid, u)
+	if err != nil {
+		if err == project.ErrLeaseHeld {
+			respondLeaseHeld(w, r, h.Deps, pid)
+			return
+		}
+		if err == project.ErrNoFound {
+			respond404(w, r)
+			return
+		}
 		h.LogErr.Println(err)
 		respond500(w, r)
 		return
 	}
 
-	respondSuccess(w, r, "succes", time.Time{})
+	respondSuccess(w, r, revisionResponse{id}, time.Time{})
 }
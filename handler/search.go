@@ -0,0 +1,47 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultSearchLimit = 20
+	maxSearchLimit     = 100
+)
+
+// ContentSearchHandler finds projects by the latest content on their main
+// branch. The query parameter q takes words, "quoted phrases", or and
+// -excluded words.
+type ContentSearchHandler struct {
+	Deps
+}
+
+func (h ContentSearchHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+
+	query := strings.TrimSpace(q.Get("q"))
+	if query == "" {
+		respondError(w, r, http.StatusBadRequest, "query required")
+		return
+	}
+
+	limit, _ := strconv.Atoi(q.Get("limit"))
+	if limit <= 0 {
+		limit = defaultSearchLimit
+	}
+	if limit > maxSearchLimit {
+		limit = maxSearchLimit
+	}
+
+	results, err := h.Deps.ProjectStore.SearchContent(query, viewerFromVars(r), limit)
+	if err != nil {
+		h.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	respondSuccess(w, r, results, time.Time{})
+}
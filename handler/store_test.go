@@ -0,0 +1,101 @@
+package handler
+
+import (
+	"io/ioutil"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/frengine/server/auth"
+	"github.com/frengine/server/config"
+	"github.com/frengine/server/org"
+	"github.com/frengine/server/project"
+	"github.com/gorilla/mux"
+)
+
+// fakeProjects is a project.Store with one project. Methods the tests don't
+// need panic, as the embedded interface is nil.
+type fakeProjects struct {
+	project.Store
+
+	p     project.Project
+	roles map[uint]project.Role
+
+	moved    bool
+	movedOrg int
+	movedTo  uint
+
+	proposed bool
+}
+
+func (s *fakeProjects) FetchByID(id int, viewer uint) (project.Project, error) {
+	if id != s.p.ID {
+		return project.Project{}, project.ErrNoFound
+	}
+	return s.p, nil
+}
+
+func (s *fakeProjects) FetchRole(pid int, uid uint) (project.Role, error) {
+	if pid != s.p.ID {
+		return "", project.ErrNoFound
+	}
+	if s.p.AuthorOwns() && uid == s.p.Author.ID {
+		return project.RoleOwner, nil
+	}
+	return s.roles[uid], nil
+}
+
+func (s *fakeProjects) Move(pid int, oid int, author auth.User) error {
+	s.moved, s.movedOrg, s.movedTo = true, oid, author.ID
+	return nil
+}
+
+func (s *fakeProjects) ProposeTransfer(pid int, to auth.User) (int, error) {
+	s.proposed = true
+	return 1, nil
+}
+
+// fakeOrgs is an org.Store with one organization.
+type fakeOrgs struct {
+	org.Store
+
+	o     org.Organization
+	roles map[uint]org.Role
+}
+
+func (s *fakeOrgs) FetchByID(id int) (org.Organization, error) {
+	if id != s.o.ID {
+		return org.Organization{}, org.ErrNoFound
+	}
+	return s.o, nil
+}
+
+func (s *fakeOrgs) FetchRole(id int, uid uint) (org.Role, error) {
+	if id != s.o.ID {
+		return "", nil
+	}
+	return s.roles[uid], nil
+}
+
+func fakeDeps(projects *fakeProjects, orgs *fakeOrgs) Deps {
+	logger := log.New(ioutil.Discard, "", 0)
+	return Deps{nil, projects, orgs, logger, logger, config.Config{}}
+}
+
+// serve runs the handler like the router would, for the logged in user and
+// the route variables.
+func serve(t *testing.T, h http.Handler, uid uint, vars map[string]string, body string) *httptest.ResponseRecorder {
+	t.Helper()
+
+	r := httptest.NewRequest("POST", "/", strings.NewReader(body))
+	vars["uid"] = strconv.Itoa(int(uid))
+	r = mux.SetURLVars(r, vars)
+
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	return w
+}
@@ -0,0 +1,145 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/frengine/server/project"
+	"github.com/gorilla/mux"
+)
+
+type TagListHandler struct {
+	Deps
+}
+
+func (h TagListHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pid, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	if _, ok := mustFetchProject(w, r, h.Deps, pid); !ok {
+		return
+	}
+
+	refs, err := h.Deps.ProjectStore.FetchTags(pid)
+	if err != nil {
+		h.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	respondSuccess(w, r, refs, time.Time{})
+}
+
+type TagGetHandler struct {
+	Deps
+}
+
+func (h TagGetHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	pid, _ := strconv.Atoi(vars["id"])
+
+	if _, ok := mustFetchProject(w, r, h.Deps, pid); !ok {
+		return
+	}
+
+	ref, err := h.Deps.ProjectStore.FetchTag(pid, vars["tag"])
+	if err != nil {
+		if err == project.ErrNoFound {
+			respond404(w, r)
+			return
+		}
+		h.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	// Tags never move, so the tag is as old as when it was made.
+	lm := time.Time{}
+	if ref.Created != nil {
+		lm = *ref.Created
+	}
+
+	respondSuccess(w, r, ref, lm)
+}
+
+type TagCreateHandler struct {
+	Deps
+}
+
+type tagCreateReq struct {
+	Name string `json:"name"`
+
+	// Revision to tag, the head of main if left out.
+	Revision int `json:"revision"`
+}
+
+func (h TagCreateHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pid, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	if _, ok := mustFetchProject(w, r, h.Deps, pid); !ok {
+		return
+	}
+
+	if !mustHaveRole(w, r, h.Deps, pid, project.RoleEditor) {
+		return
+	}
+
+	req := tagCreateReq{}
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil {
+		respondError(w, r, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	err := h.Deps.ProjectStore.CreateTag(pid, req.Name, req.Revision)
+	if err != nil {
+		switch err {
+		case project.ErrInvalidRefName:
+			respondError(w, r, http.StatusBadRequest, "invalid tag name")
+		case project.ErrAlreadyExists:
+			respondError(w, r, http.StatusConflict, "tag or branch already exists")
+		case project.ErrNoFound:
+			respondError(w, r, http.StatusBadRequest, "invalid revision")
+		case project.ErrEmptyProject:
+			respondError(w, r, http.StatusBadRequest, "project has no revisions")
+		default:
+			h.LogErr.Println(err)
+			respond500(w, r)
+		}
+		return
+	}
+
+	respondSuccess(w, r, "success", time.Time{})
+}
+
+type TagDeleteHandler struct {
+	Deps
+}
+
+func (h TagDeleteHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	vars := mux.Vars(r)
+	pid, _ := strconv.Atoi(vars["id"])
+
+	if _, ok := mustFetchProject(w, r, h.Deps, pid); !ok {
+		return
+	}
+
+	if !mustHaveRole(w, r, h.Deps, pid, project.RoleEditor) {
+		return
+	}
+
+	err := h.Deps.ProjectStore.DeleteTag(pid, vars["tag"])
+	if err != nil {
+		if err == project.ErrNoFound {
+			respond404(w, r)
+			return
+		}
+		h.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	respondSuccess(w, r, "success", time.Time{})
+}
@@ -0,0 +1,35 @@
+package handler
+
+import (
+	"net/http"
+
+	"github.com/frengine/server/config"
+)
+
+// TemplateListHandler lists the template projects the user can start a new
+// project from, which the admins picked. It takes the same query parameters
+// as ProjectListHandler.
+type TemplateListHandler struct {
+	Deps
+}
+
+func (h TemplateListHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	opts, err := searchOptionsFromRequest(r)
+	if err != nil {
+		respondError(w, r, http.StatusBadRequest, err.Error())
+		return
+	}
+	opts.Template = true
+
+	respondProjects(w, r, h.Deps, opts)
+}
+
+// isAdmin tells whether the user is one of the admins in the configuration.
+func isAdmin(cfg config.Config, uid uint) bool {
+	for _, admin := range cfg.Admins {
+		if uid != 0 && admin == uid {
+			return true
+		}
+	}
+	return false
+}
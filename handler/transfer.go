@@ -0,0 +1,176 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/frengine/server/auth"
+	"github.com/frengine/server/project"
+	"github.com/gorilla/mux"
+)
+
+// TransferProposeHandler proposes to hand a project over to another user. The
+// project only changes hands when they accept, see TransferAcceptHandler.
+type TransferProposeHandler struct {
+	Deps
+}
+
+type transferReq struct {
+	To uint `json:"to"`
+}
+
+type transferResponse struct {
+	TransferID int `json:"transferID"`
+}
+
+func (h TransferProposeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pid, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	p, ok := mustFetchProject(w, r, h.Deps, pid)
+	if !ok {
+		return
+	}
+
+	u, err := getUserFromVars(r)
+	if err != nil {
+		h.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	// Only the author can give the project away, not other owners.
+	if u.ID != p.Author.ID {
+		respondError(w, r, http.StatusForbidden, "forbidden")
+		return
+	}
+
+	req := transferReq{}
+
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&req); err != nil {
+		respondError(w, r, http.StatusBadRequest, "invalid json")
+		return
+	}
+
+	if !p.AuthorOwns() {
+		respondError(w, r, http.StatusBadRequest, "the project belongs to an organization")
+		return
+	}
+
+	if req.To == 0 || req.To == u.ID {
+		respondError(w, r, http.StatusBadRequest, "invalid user")
+		return
+	}
+
+	id, err := h.Deps.ProjectStore.ProposeTransfer(pid, auth.User{ID: req.To})
+	if err != nil {
+		switch err {
+		case project.ErrInvalidUser:
+			respondError(w, r, http.StatusBadRequest, "invalid user")
+		case project.ErrNoFound:
+			respond404(w, r)
+		default:
+			h.LogErr.Println(err)
+			respond500(w, r)
+		}
+		return
+	}
+
+	respondSuccess(w, r, transferResponse{id}, time.Time{})
+}
+
+type TransferCancelHandler struct {
+	Deps
+}
+
+func (h TransferCancelHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pid, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	if _, ok := mustFetchProject(w, r, h.Deps, pid); !ok {
+		return
+	}
+
+	if !mustHaveRole(w, r, h.Deps, pid, project.RoleOwner) {
+		return
+	}
+
+	err := h.Deps.ProjectStore.CancelTransfer(pid)
+	if err != nil {
+		if err == project.ErrNoFound {
+			respond404(w, r)
+			return
+		}
+		h.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	respondSuccess(w, r, "success", time.Time{})
+}
+
+// TransferListHandler lists the transfers waiting for the user to accept or
+// decline them.
+type TransferListHandler struct {
+	Deps
+}
+
+func (h TransferListHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, err := getUserFromVars(r)
+	if err != nil {
+		h.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	ts, err := h.Deps.ProjectStore.FetchTransfers(u.ID)
+	if err != nil {
+		h.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	respondSuccess(w, r, ts, time.Time{})
+}
+
+type TransferAcceptHandler struct {
+	Deps
+}
+
+func (h TransferAcceptHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	answerTransfer(w, r, h.Deps, h.Deps.ProjectStore.AcceptTransfer)
+}
+
+type TransferDeclineHandler struct {
+	Deps
+}
+
+func (h TransferDeclineHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	answerTransfer(w, r, h.Deps, h.Deps.ProjectStore.DeclineTransfer)
+}
+
+// answerTransfer accepts or declines the transfer in the route for the user.
+func answerTransfer(w http.ResponseWriter, r *http.Request, d Deps, answer func(id int, uid uint) error) {
+	tid, _ := strconv.Atoi(mux.Vars(r)["transfer"])
+
+	u, err := getUserFromVars(r)
+	if err != nil {
+		d.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	err = answer(tid, u.ID)
+	if err != nil {
+		if err == project.ErrNoFound {
+			respond404(w, r)
+			return
+		}
+		d.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	respondSuccess(w, r, "success", time.Time{})
+}
@@ -0,0 +1,42 @@
+package handler
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/frengine/server/auth"
+	"github.com/frengine/server/project"
+)
+
+func TestTransferPropose(t *testing.T) {
+	tests := []struct {
+		name string
+		user uint
+		body string
+		code int
+	}{
+		{"author to someone else", testAuthor, `{"to": 4}`, http.StatusOK},
+		{"member owner to themselves", testOwner, `{"to": 2}`, http.StatusForbidden},
+		{"member owner to someone else", testOwner, `{"to": 4}`, http.StatusForbidden},
+		{"author to themselves", testAuthor, `{"to": 1}`, http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			projects := &fakeProjects{
+				p:     project.Project{ID: 1, Author: &auth.User{ID: testAuthor}},
+				roles: map[uint]project.Role{testOwner: project.RoleOwner},
+			}
+			h := TransferProposeHandler{fakeDeps(projects, &fakeOrgs{})}
+
+			w := serve(t, h, tt.user, map[string]string{"id": "1"}, tt.body)
+
+			if w.Code != tt.code {
+				t.Fatalf("got status %d, want %d: %s", w.Code, tt.code, w.Body)
+			}
+			if projects.proposed != (tt.code == http.StatusOK) {
+				t.Errorf("proposed is %v", projects.proposed)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/frengine/server/project"
+	"github.com/gorilla/mux"
+)
+
+// TrashListHandler lists the deleted projects the user owns.
+type TrashListHandler struct {
+	Deps
+}
+
+func (h TrashListHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	u, err := getUserFromVars(r)
+	if err != nil {
+		h.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	ps, err := h.Deps.ProjectStore.FetchTrash(u.ID)
+	if err != nil {
+		h.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	respondSuccess(w, r, ps, time.Time{})
+}
+
+type TrashRestoreHandler struct {
+	Deps
+}
+
+func (h TrashRestoreHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pid, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	if !mustHaveRole(w, r, h.Deps, pid, project.RoleOwner) {
+		return
+	}
+
+	err := h.Deps.ProjectStore.Undelete(pid)
+	if err != nil {
+		if err == project.ErrNoFound {
+			respond404(w, r)
+			return
+		}
+		h.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	respondSuccess(w, r, "success", time.Time{})
+}
+
+// TrashPurgeHandler removes a deleted project for good, with all its
+// revisions.
+type TrashPurgeHandler struct {
+	Deps
+}
+
+func (h TrashPurgeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	pid, _ := strconv.Atoi(mux.Vars(r)["id"])
+
+	if !mustHaveRole(w, r, h.Deps, pid, project.RoleOwner) {
+		return
+	}
+
+	err := h.Deps.ProjectStore.Purge(pid)
+	if err != nil {
+		if err == project.ErrNoFound {
+			respond404(w, r)
+			return
+		}
+		h.LogErr.Println(err)
+		respond500(w, r)
+		return
+	}
+
+	respondSuccess(w, r, "success", time.Time{})
+}
@@ -9,8 +9,11 @@ import (
 	"time"
 
 	"github.com/frengine/server/auth"
+	"github.com/frengine/server/collab"
 	"github.com/frengine/server/config"
+	"github.com/frengine/server/events"
 	"github.com/frengine/server/handler"
+	"github.com/frengine/server/org"
 	"github.com/frengine/server/project"
 	"github.com/gorilla/mux"
 	_ "github.com/lib/pq"
@@ -48,14 +51,39 @@ func main() {
 		return
 	}
 
+	bus := events.NewBus()
+
+	projectStore := project.PostgresStore{db, cfg.Storage.SnapshotInterval, bus}
+
 	deps := handler.Deps{
 		auth.PostgresStore{db},
-		project.PostgresStore{db},
+		projectStore,
+		org.PostgresStore{db},
 		log.New(os.Stdout, "", log.Ldate|log.Ltime),
 		log.New(os.Stderr, "", log.Ldate|log.Ltime|log.Llongfile),
 		cfg,
 	}
 
+	go func() {
+		deps.LogErr.Fatal(projectStore.Listen(cfg.MakeDBString(), deps.LogErr))
+	}()
+
+	go func() {
+		n, err := projectStore.IndexMissing()
+		if err != nil {
+			deps.LogErr.Println(err)
+		}
+		if n > 0 {
+			deps.LogInfo.Printf("Indexed %d projects for search\n", n)
+		}
+	}()
+
+	if days := cfg.Trash.RetentionDays; days > 0 {
+		go purgeTrash(projectStore, time.Duration(days)*24*time.Hour, deps)
+	}
+
+	hub := collab.NewHub(deps.ProjectStore, deps.LogErr, collab.DefaultCheckpointInterval)
+
 	r := mux.NewRouter()
 
 	api := r.PathPrefix("/api").Subrouter()
@@ -69,12 +97,26 @@ func main() {
 
 	{
 		s := api.PathPrefix("/projects").Subrouter()
+		s.Use(handler.AuthWare{deps}.OptionalMiddleware)
 
 		s.Handle("", handler.ProjectListHandler{deps}).Methods("GET")
 
 		s.Handle("/{id}", handler.ProjectGetHandler{deps}).Methods("GET")
 
 		s.Handle("/{id}/revision", handler.RevisionGetHandler{deps}).Methods("GET")
+		s.Handle("/{id}/revisions", handler.RevisionListHandler{deps}).Methods("GET")
+		s.Handle("/{id}/revisions/{rid}", handler.RevisionFetchHandler{deps}).Methods("GET")
+		s.Handle("/{id}/revisions/{a}/diff/{b}", handler.RevisionDiffHandler{deps}).Methods("GET")
+
+		s.Handle("/{id}/branches", handler.BranchListHandler{deps}).Methods("GET")
+		s.Handle("/{id}/branches/{branch}/revision", handler.RevisionGetHandler{deps}).Methods("GET")
+
+		s.Handle("/{id}/members", handler.MemberListHandler{deps}).Methods("GET")
+		s.Handle("/{id}/forks", handler.ForkListHandler{deps}).Methods("GET")
+		s.Handle("/{id}/export", handler.ExportHandler{deps}).Methods("GET")
+
+		s.Handle("/{id}/tags", handler.TagListHandler{deps}).Methods("GET")
+		s.Handle("/{id}/tags/{tag}", handler.TagGetHandler{deps}).Methods("GET")
 
 		{
 			s := api.PathPrefix("/projects").Subrouter()
@@ -84,12 +126,102 @@ func main() {
 
 			s.Handle("/{id}", handler.ProjectUpdateHandler{deps}).Methods("PUT")
 			s.Handle("/{id}", handler.ProjectDeleteHandler{deps}).Methods("DELETE")
+			s.Handle("/{id}/fork", handler.ProjectForkHandler{deps}).Methods("POST")
+			s.Handle("/{id}/transfer", handler.TransferProposeHandler{deps}).Methods("POST")
+			s.Handle("/{id}/transfer", handler.TransferCancelHandler{deps}).Methods("DELETE")
+			s.Handle("/{id}/organization", handler.ProjectMoveHandler{deps}).Methods("PUT")
 
 			s.Handle("/{id}/revision", handler.RevisionSaveHandler{deps}).Methods("POST")
+			s.Handle("/{id}/revision", handler.RevisionPatchHandler{deps}).Methods("PATCH")
+			s.Handle("/{id}/revisions/{rid}/restore", handler.RevisionRestoreHandler{deps}).Methods("POST")
+
+			s.Handle("/{id}/members", handler.MemberAddHandler{deps}).Methods("POST")
+			s.Handle("/{id}/members/{member}", handler.MemberUpdateHandler{deps}).Methods("PUT")
+			s.Handle("/{id}/members/{member}", handler.MemberRemoveHandler{deps}).Methods("DELETE")
+
+			s.Handle("/{id}/lease", handler.LeaseAcquireHandler{deps}).Methods("POST")
+			s.Handle("/{id}/lease", handler.LeaseRenewHandler{deps}).Methods("PUT")
+			s.Handle("/{id}/lease", handler.LeaseReleaseHandler{deps}).Methods("DELETE")
+
+			s.Handle("/{id}/branches", handler.BranchCreateHandler{deps}).Methods("POST")
+			s.Handle("/{id}/branches/{branch}", handler.BranchDeleteHandler{deps}).Methods("DELETE")
+			s.Handle("/{id}/branches/{branch}/revision", handler.RevisionSaveHandler{deps}).Methods("POST")
+			s.Handle("/{id}/branches/{branch}/revision", handler.RevisionPatchHandler{deps}).Methods("PATCH")
+
+			s.Handle("/{id}/tags", handler.TagCreateHandler{deps}).Methods("POST")
+			s.Handle("/{id}/tags/{tag}", handler.TagDeleteHandler{deps}).Methods("DELETE")
+		}
+
+		// Browsers can't set headers on these, so they take the token from
+		// the URL too.
+		{
+			s := api.PathPrefix("/projects").Subrouter()
+			s.Use(handler.AuthWare{deps}.OptionalStreamMiddleware)
+
+			s.Handle("/{id}/events", handler.EventStreamHandler{deps, bus}).Methods("GET")
+		}
+
+		{
+			s := api.PathPrefix("/projects").Subrouter()
+			s.Use(handler.AuthWare{deps}.StreamMiddleware)
+
+			s.Handle("/{id}/collab", handler.CollabHandler{deps, hub}).Methods("GET")
 		}
 
 	}
 
+	{
+		s := api.PathPrefix("/templates").Subrouter()
+		s.Use(handler.AuthWare{deps}.OptionalMiddleware)
+
+		s.Handle("", handler.TemplateListHandler{deps}).Methods("GET")
+	}
+
+	{
+		s := api.PathPrefix("/transfers").Subrouter()
+		s.Use(handler.AuthWare{deps}.Middleware)
+
+		s.Handle("", handler.TransferListHandler{deps}).Methods("GET")
+		s.Handle("/{transfer}/accept", handler.TransferAcceptHandler{deps}).Methods("POST")
+		s.Handle("/{transfer}/decline", handler.TransferDeclineHandler{deps}).Methods("POST")
+	}
+
+	{
+		s := api.PathPrefix("/trash").Subrouter()
+		s.Use(handler.AuthWare{deps}.Middleware)
+
+		s.Handle("", handler.TrashListHandler{deps}).Methods("GET")
+		s.Handle("/{id}/restore", handler.TrashRestoreHandler{deps}).Methods("POST")
+		s.Handle("/{id}", handler.TrashPurgeHandler{deps}).Methods("DELETE")
+	}
+
+	{
+		s := api.PathPrefix("/search").Subrouter()
+		s.Use(handler.AuthWare{deps}.OptionalMiddleware)
+
+		s.Handle("", handler.ContentSearchHandler{deps}).Methods("GET")
+	}
+
+	{
+		s := api.PathPrefix("/orgs").Subrouter()
+		s.Use(handler.AuthWare{deps}.OptionalMiddleware)
+
+		s.Handle("/{org}", handler.OrgGetHandler{deps}).Methods("GET")
+		s.Handle("/{org}/members", handler.OrgMemberListHandler{deps}).Methods("GET")
+		s.Handle("/{org}/projects", handler.OrgProjectListHandler{deps}).Methods("GET")
+
+		{
+			s := api.PathPrefix("/orgs").Subrouter()
+			s.Use(handler.AuthWare{deps}.Middleware)
+
+			s.Handle("", handler.OrgCreateHandler{deps}).Methods("POST")
+
+			s.Handle("/{org}/members", handler.OrgMemberAddHandler{deps}).Methods("POST")
+			s.Handle("/{org}/members/{member}", handler.OrgMemberUpdateHandler{deps}).Methods("PUT")
+			s.Handle("/{org}/members/{member}", handler.OrgMemberRemoveHandler{deps}).Methods("DELETE")
+		}
+	}
+
 	srv := http.Server{
 		Addr:    ":8083",
 		Handler: r,
@@ -102,3 +234,19 @@ func main() {
 
 	deps.LogErr.Fatal(srv.ListenAndServe())
 }
+
+// purgeTrash regularly purges the projects that have been in the trash for
+// longer than the retention period.
+func purgeTrash(store project.Store, retention time.Duration, deps handler.Deps) {
+	for {
+		n, err := store.PurgeDeletedBefore(time.Now().Add(-retention))
+		if err != nil {
+			deps.LogErr.Println(err)
+		}
+		if n > 0 {
+			deps.LogInfo.Printf("Purged %d projects from the trash\n", n)
+		}
+
+		time.Sleep(time.Hour)
+	}
+}
@@ -0,0 +1,9 @@
+ALTER TABLE revision ADD parent_id integer REFERENCES revision (id);
+
+/* Link existing revisions to the one saved before them. */
+UPDATE revision SET parent_id = prev.parent_id
+FROM (
+	SELECT id, LAG(id) OVER (PARTITION BY project_id ORDER BY created, id) AS parent_id
+	FROM revision
+) AS prev
+WHERE revision.id = prev.id;
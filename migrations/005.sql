@@ -0,0 +1,18 @@
+CREATE TABLE ref (
+	id SERIAL,
+	project_id integer NOT NULL REFERENCES project,
+	name VARCHAR(255) NOT NULL,
+	revision_id integer REFERENCES revision,
+	created timestamp DEFAULT current_timestamp,
+
+	constraint fk_project_ref foreign key (project_id) REFERENCES project (id),
+
+	UNIQUE (project_id, name),
+	PRIMARY KEY (id)
+);
+
+/* Every project with revisions gets a main branch at its latest revision. */
+INSERT INTO ref (project_id, name, revision_id)
+SELECT DISTINCT ON (project_id) project_id, 'main', id
+FROM revision
+ORDER BY project_id, created DESC, id DESC;
@@ -0,0 +1,2 @@
+/* Tags share the ref table (and so the names) with branches, but never move. */
+ALTER TABLE ref ADD tag boolean NOT NULL DEFAULT false;
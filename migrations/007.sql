@@ -0,0 +1,7 @@
+ALTER TABLE revision ADD author_id integer REFERENCES account (id);
+ALTER TABLE revision ADD message TEXT;
+
+/* Before this, only the project author could save revisions. */
+UPDATE revision SET author_id = project.author_id
+FROM project
+WHERE revision.project_id = project.id;
@@ -0,0 +1,20 @@
+CREATE TABLE blob (
+	hash CHAR(64) NOT NULL,
+	content TEXT NOT NULL,
+	created timestamp DEFAULT current_timestamp,
+
+	PRIMARY KEY (hash)
+);
+
+ALTER TABLE revision ADD blob_hash CHAR(64) REFERENCES blob (hash);
+
+/* Move the contents into blobs, keyed by their SHA-256, and store each one once. */
+INSERT INTO blob (hash, content)
+SELECT DISTINCT encode(sha256(convert_to(content, 'UTF8')), 'hex'), content
+FROM revision
+WHERE content IS NOT NULL;
+
+UPDATE revision SET blob_hash = encode(sha256(convert_to(content, 'UTF8')), 'hex')
+WHERE content IS NOT NULL;
+
+ALTER TABLE revision DROP COLUMN content;
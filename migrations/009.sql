@@ -0,0 +1,8 @@
+/* Blobs are stored either in full, or as a delta against another blob. */
+ALTER TABLE blob ALTER content DROP NOT NULL;
+ALTER TABLE blob ADD delta BYTEA;
+ALTER TABLE blob ADD delta_base CHAR(64) REFERENCES blob (hash);
+/* Number of deltas to apply to get to the content, 0 for full snapshots. */
+ALTER TABLE blob ADD depth integer NOT NULL DEFAULT 0;
+
+ALTER TABLE blob ADD CONSTRAINT blob_content_or_delta CHECK ((content IS NULL) <> (delta IS NULL));
@@ -0,0 +1,13 @@
+CREATE TABLE event (
+	id BIGSERIAL,
+	project_id integer NOT NULL REFERENCES project,
+	type VARCHAR(30) NOT NULL,
+	revision_id integer REFERENCES revision,
+	branch VARCHAR(255),
+	name VARCHAR(255),
+	created timestamp DEFAULT current_timestamp,
+
+	PRIMARY KEY (id)
+);
+
+CREATE INDEX event_project_id ON event (project_id, id);
@@ -0,0 +1,7 @@
+CREATE TABLE lease (
+	project_id integer NOT NULL REFERENCES project,
+	holder_id integer NOT NULL REFERENCES account (id),
+	expires timestamp NOT NULL,
+
+	PRIMARY KEY (project_id)
+);
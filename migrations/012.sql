@@ -0,0 +1,8 @@
+CREATE TABLE project_member (
+	project_id integer NOT NULL REFERENCES project,
+	account_id integer NOT NULL REFERENCES account (id),
+	role VARCHAR(30) NOT NULL,
+	created timestamp DEFAULT current_timestamp,
+
+	PRIMARY KEY (project_id, account_id)
+);
@@ -0,0 +1 @@
+ALTER TABLE project ADD visibility VARCHAR(30) NOT NULL DEFAULT 'public';
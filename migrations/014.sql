@@ -0,0 +1,18 @@
+CREATE TABLE organization (
+	id SERIAL,
+	name VARCHAR(255) UNIQUE NOT NULL,
+	created timestamp DEFAULT current_timestamp,
+
+	PRIMARY KEY (id)
+);
+
+CREATE TABLE organization_member (
+	organization_id integer NOT NULL REFERENCES organization,
+	account_id integer NOT NULL REFERENCES account (id),
+	role VARCHAR(30) NOT NULL,
+	created timestamp DEFAULT current_timestamp,
+
+	PRIMARY KEY (organization_id, account_id)
+);
+
+ALTER TABLE project ADD organization_id integer REFERENCES organization;
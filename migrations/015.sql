@@ -0,0 +1,13 @@
+ALTER TABLE project ADD search tsvector;
+
+CREATE INDEX project_search ON project USING GIN (search);
+
+/* Index the latest content of every project. Content stored as a delta is
+   indexed by the server when it starts, see PostgresStore.IndexMissing. */
+UPDATE project SET search = to_tsvector('simple', left(blob.content, 524288))
+FROM ref
+INNER JOIN revision
+	ON revision.id = ref.revision_id
+INNER JOIN blob
+	ON blob.hash = revision.blob_hash
+WHERE ref.project_id = project.id AND ref.name = 'main' AND NOT ref.tag AND blob.content IS NOT NULL;
@@ -0,0 +1,3 @@
+ALTER TABLE project ADD upstream_id integer REFERENCES project (id);
+
+CREATE INDEX project_upstream_id ON project (upstream_id);
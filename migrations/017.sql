@@ -0,0 +1 @@
+ALTER TABLE project ADD template boolean NOT NULL DEFAULT false;
@@ -0,0 +1,10 @@
+CREATE TABLE transfer (
+	id SERIAL,
+	project_id integer NOT NULL REFERENCES project,
+	from_id integer NOT NULL REFERENCES account (id),
+	to_id integer NOT NULL REFERENCES account (id),
+	created timestamp DEFAULT current_timestamp,
+
+	UNIQUE (project_id),
+	PRIMARY KEY (id)
+);
@@ -0,0 +1,236 @@
+package org
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/frengine/server/auth"
+	"github.com/lib/pq"
+)
+
+type Organization struct {
+	ID   int    `json:"id"`
+	Name string `json:"name"`
+
+	Created    *time.Time `json:"-"`
+	CreatedUTS int64      `json:"created,omitempty"`
+}
+
+// Role says what a member may do with the organization and its projects.
+type Role string
+
+const (
+	// RoleOwner can manage the members, and owns the projects of the
+	// organization.
+	RoleOwner Role = "owner"
+	// RoleMember can edit the projects of the organization.
+	RoleMember Role = "member"
+)
+
+func ValidRole(role Role) bool {
+	return role == RoleOwner || role == RoleMember
+}
+
+type Member struct {
+	User *auth.User `json:"user"`
+	Role Role       `json:"role"`
+
+	Created    *time.Time `json:"-"`
+	CreatedUTS int64      `json:"created"`
+}
+
+type Store interface {
+	Create(name string, owner auth.User) (int, error)
+	FetchByID(id int) (Organization, error)
+
+	// FetchRole returns the role the user has in the organization, or the
+	// empty role if they aren't a member.
+	FetchRole(id int, uid uint) (Role, error)
+	FetchMembers(id int) ([]Member, error)
+	AddMember(id int, uid uint, role Role) error
+	UpdateMember(id int, uid uint, role Role) error
+	RemoveMember(id int, uid uint) error
+}
+
+type PostgresStore struct {
+	DB *sql.DB
+}
+
+var (
+	ErrNoFound       = errors.New("no organizations found")
+	ErrAlreadyExists = errors.New("organization already exists")
+	ErrInvalidName   = errors.New("invalid name")
+	ErrInvalidRole   = errors.New("invalid role")
+	ErrInvalidUser   = errors.New("invalid user")
+	ErrLastOwner     = errors.New("an organization needs an owner")
+)
+
+// Create makes a new organization, with owner as its first owner.
+func (s PostgresStore) Create(name string, owner auth.User) (int, error) {
+	if name == "" || len(name) > 255 {
+		return 0, ErrInvalidName
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var id int
+	err = tx.QueryRow(`INSERT INTO organization (name) VALUES ($1) RETURNING id;`, name).Scan(&id)
+	if err != nil {
+		pqErr, ok := err.(*pq.Error)
+		if ok && pqErr.Code == "23505" {
+			return 0, ErrAlreadyExists
+		}
+		return 0, err
+	}
+
+	_, err = tx.Exec(`INSERT INTO organization_member (organization_id, account_id, role) VALUES ($1, $2, $3);`, id, owner.ID, RoleOwner)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, tx.Commit()
+}
+
+func (s PostgresStore) FetchByID(id int) (Organization, error) {
+	o := Organization{}
+
+	err := s.DB.QueryRow(`SELECT id, name, created FROM organization WHERE id=$1;`, id).Scan(&o.ID, &o.Name, &o.Created)
+	if err == sql.ErrNoRows {
+		return o, ErrNoFound
+	}
+	if err != nil {
+		return o, err
+	}
+
+	if o.Created != nil {
+		o.CreatedUTS = o.Created.Unix()
+	}
+
+	return o, nil
+}
+
+func (s PostgresStore) FetchRole(id int, uid uint) (Role, error) {
+	var role Role
+
+	err := s.DB.QueryRow(`SELECT role FROM organization_member WHERE organization_id=$1 AND account_id=$2;`, id, uid).Scan(&role)
+	if err == sql.ErrNoRows {
+		return "", nil
+	}
+
+	return role, err
+}
+
+func (s PostgresStore) FetchMembers(id int) ([]Member, error) {
+	q := `SELECT account.id, account.login, organization_member.role, organization_member.created
+	FROM organization_member
+	INNER JOIN account
+		ON account.id = organization_member.account_id
+	WHERE organization_member.organization_id=$1
+	ORDER BY organization_member.role = 'owner' DESC, account.login;`
+
+	rows, err := s.DB.Query(q, id)
+	if err != nil {
+		return []Member{}, err
+	}
+	defer rows.Close()
+
+	ms := []Member{}
+
+	for rows.Next() {
+		m := Member{User: &auth.User{}}
+
+		err := rows.Scan(&m.User.ID, &m.User.Name, &m.Role, &m.Created)
+		if err != nil {
+			return ms, err
+		}
+
+		if m.Created != nil {
+			m.CreatedUTS = m.Created.Unix()
+		}
+
+		ms = append(ms, m)
+	}
+
+	return ms, rows.Err()
+}
+
+func (s PostgresStore) AddMember(id int, uid uint, role Role) error {
+	if !ValidRole(role) {
+		return ErrInvalidRole
+	}
+
+	_, err := s.DB.Exec(`INSERT INTO organization_member (organization_id, account_id, role) VALUES ($1, $2, $3);`, id, uid, role)
+	if err != nil {
+		pqErr, ok := err.(*pq.Error)
+		if ok && pqErr.Code == "23505" {
+			return ErrAlreadyExists
+		}
+		if ok && pqErr.Code == "23503" {
+			return ErrInvalidUser
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (s PostgresStore) UpdateMember(id int, uid uint, role Role) error {
+	if !ValidRole(role) {
+		return ErrInvalidRole
+	}
+
+	return s.changeMember(id, `UPDATE organization_member SET role = $3 WHERE organization_id=$1 AND account_id=$2;`, uid, role)
+}
+
+func (s PostgresStore) RemoveMember(id int, uid uint) error {
+	return s.changeMember(id, `DELETE FROM organization_member WHERE organization_id=$1 AND account_id=$2;`, uid)
+}
+
+// changeMember runs q on a member, making sure the organization keeps at
+// least one owner.
+func (s PostgresStore) changeMember(id int, q string, args ...interface{}) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// Lock the organization, so two owners can't demote each other at the
+	// same time.
+	err = tx.QueryRow(`SELECT id FROM organization WHERE id=$1 FOR UPDATE;`, id).Scan(&id)
+	if err == sql.ErrNoRows {
+		return ErrNoFound
+	}
+	if err != nil {
+		return err
+	}
+
+	result, err := tx.Exec(q, append([]interface{}{id}, args...)...)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNoFound
+	}
+
+	var owners int
+	err = tx.QueryRow(`SELECT COUNT(*) FROM organization_member WHERE organization_id=$1 AND role = 'owner';`, id).Scan(&owners)
+	if err != nil {
+		return err
+	}
+	if owners == 0 {
+		return ErrLastOwner
+	}
+
+	return tx.Commit()
+}
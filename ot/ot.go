@@ -0,0 +1,349 @@
+package ot
+
+import (
+	"encoding/json"
+	"errors"
+	"unicode/utf8"
+)
+
+// Operation is a text operation in the style of ot.js: a list of components
+// that together walk over the whole document, retaining, inserting or
+// deleting text. Lengths count Unicode code points.
+//
+// In JSON an operation is an array where positive numbers retain, negative
+// numbers delete and strings insert, the same as ot.js' TextOperation.
+type Operation struct {
+	comps []comp
+
+	// BaseLen is the length of the documents the operation applies to,
+	// TargetLen the length of the result.
+	BaseLen   int
+	TargetLen int
+}
+
+// comp is a retain (n > 0), delete (n < 0) or insert (s != "").
+type comp struct {
+	n int
+	s string
+}
+
+var (
+	ErrInvalid        = errors.New("invalid operation")
+	ErrLengthMismatch = errors.New("operation does not fit the document")
+)
+
+// MaxLen is the longest document operations can apply to or produce. Longer
+// counts are rejected when decoding, so adding them up can't overflow.
+const MaxLen = 1 << 30
+
+// check makes sure the components add up to BaseLen and TargetLen without
+// going over MaxLen.
+func (o Operation) check() error {
+	base, target := 0, 0
+
+	for _, c := range o.comps {
+		switch {
+		case c.s != "":
+			n := utf8.RuneCountInString(c.s)
+			if n > MaxLen-target {
+				return ErrInvalid
+			}
+			target += n
+		case c.n > 0:
+			if c.n > MaxLen-base || c.n > MaxLen-target {
+				return ErrInvalid
+			}
+			base += c.n
+			target += c.n
+		default:
+			if c.n < -(MaxLen - base) {
+				return ErrInvalid
+			}
+			base -= c.n
+		}
+	}
+
+	if base != o.BaseLen || target != o.TargetLen {
+		return ErrInvalid
+	}
+
+	return nil
+}
+
+func (o *Operation) Retain(n int) *Operation {
+	if n <= 0 {
+		return o
+	}
+
+	o.BaseLen += n
+	o.TargetLen += n
+
+	if l := len(o.comps); l > 0 && o.comps[l-1].n > 0 {
+		o.comps[l-1].n += n
+	} else {
+		o.comps = append(o.comps, comp{n: n})
+	}
+
+	return o
+}
+
+func (o *Operation) Insert(s string) *Operation {
+	if s == "" {
+		return o
+	}
+
+	o.TargetLen += utf8.RuneCountInString(s)
+
+	l := len(o.comps)
+	switch {
+	case l > 0 && o.comps[l-1].s != "":
+		o.comps[l-1].s += s
+	case l > 0 && o.comps[l-1].n < 0:
+		// Inserts always go before deletes, so equal operations look the
+		// same.
+		if l > 1 && o.comps[l-2].s != "" {
+			o.comps[l-2].s += s
+		} else {
+			o.comps = append(o.comps, o.comps[l-1])
+			o.comps[l-1] = comp{s: s}
+		}
+	default:
+		o.comps = append(o.comps, comp{s: s})
+	}
+
+	return o
+}
+
+func (o *Operation) Delete(n int) *Operation {
+	if n <= 0 {
+		return o
+	}
+
+	o.BaseLen += n
+
+	if l := len(o.comps); l > 0 && o.comps[l-1].n < 0 {
+		o.comps[l-1].n -= n
+	} else {
+		o.comps = append(o.comps, comp{n: -n})
+	}
+
+	return o
+}
+
+// IsNoop tells if the operation doesn't change anything.
+func (o Operation) IsNoop() bool {
+	return len(o.comps) == 0 || (len(o.comps) == 1 && o.comps[0].n > 0)
+}
+
+func (o Operation) Apply(doc string) (string, error) {
+	if err := o.check(); err != nil {
+		return "", err
+	}
+
+	runes := []rune(doc)
+	if len(runes) != o.BaseLen {
+		return "", ErrLengthMismatch
+	}
+
+	out := make([]rune, 0, o.TargetLen)
+	pos := 0
+
+	for _, c := range o.comps {
+		if c.s != "" {
+			out = append(out, []rune(c.s)...)
+			continue
+		}
+
+		n := c.n
+		if n < 0 {
+			n = -n
+		}
+		if n > len(runes)-pos {
+			return "", ErrLengthMismatch
+		}
+
+		if c.n > 0 {
+			out = append(out, runes[pos:pos+n]...)
+		}
+		pos += n
+	}
+
+	if pos != len(runes) {
+		return "", ErrLengthMismatch
+	}
+
+	return string(out), nil
+}
+
+// Transform takes two operations a and b that both apply to the same
+// document, and returns a' and b' so that applying a then b' gives the same
+// result as b then a'. Inserts of a go first when both insert at the same
+// place.
+func Transform(a, b Operation) (Operation, Operation, error) {
+	if err := a.check(); err != nil {
+		return Operation{}, Operation{}, err
+	}
+	if err := b.check(); err != nil {
+		return Operation{}, Operation{}, err
+	}
+	if a.BaseLen != b.BaseLen {
+		return Operation{}, Operation{}, ErrLengthMismatch
+	}
+
+	ap, bp := Operation{}, Operation{}
+
+	i, j := 0, 0
+	var ca, cb *comp
+	next := func(comps []comp, k *int) *comp {
+		if *k >= len(comps) {
+			return nil
+		}
+		c := comps[*k]
+		*k++
+		return &c
+	}
+	ca = next(a.comps, &i)
+	cb = next(b.comps, &j)
+
+	for ca != nil || cb != nil {
+		if ca != nil && ca.s != "" {
+			ap.Insert(ca.s)
+			bp.Retain(utf8.RuneCountInString(ca.s))
+			ca = next(a.comps, &i)
+			continue
+		}
+		if cb != nil && cb.s != "" {
+			ap.Retain(utf8.RuneCountInString(cb.s))
+			bp.Insert(cb.s)
+			cb = next(b.comps, &j)
+			continue
+		}
+		if ca == nil || cb == nil {
+			return Operation{}, Operation{}, ErrInvalid
+		}
+
+		switch {
+		case ca.n > 0 && cb.n > 0:
+			var minl int
+			switch {
+			case ca.n > cb.n:
+				minl = cb.n
+				ca.n -= cb.n
+				cb = next(b.comps, &j)
+			case ca.n == cb.n:
+				minl = cb.n
+				ca = next(a.comps, &i)
+				cb = next(b.comps, &j)
+			default:
+				minl = ca.n
+				cb.n -= ca.n
+				ca = next(a.comps, &i)
+			}
+			ap.Retain(minl)
+			bp.Retain(minl)
+		case ca.n < 0 && cb.n < 0:
+			// Both deleted the same text.
+			switch {
+			case -ca.n > -cb.n:
+				ca.n -= cb.n
+				cb = next(b.comps, &j)
+			case ca.n == cb.n:
+				ca = next(a.comps, &i)
+				cb = next(b.comps, &j)
+			default:
+				cb.n -= ca.n
+				ca = next(a.comps, &i)
+			}
+		case ca.n < 0 && cb.n > 0:
+			var minl int
+			switch {
+			case -ca.n > cb.n:
+				minl = cb.n
+				ca.n += cb.n
+				cb = next(b.comps, &j)
+			case -ca.n == cb.n:
+				minl = cb.n
+				ca = next(a.comps, &i)
+				cb = next(b.comps, &j)
+			default:
+				minl = -ca.n
+				cb.n += ca.n
+				ca = next(a.comps, &i)
+			}
+			ap.Delete(minl)
+		case ca.n > 0 && cb.n < 0:
+			var minl int
+			switch {
+			case ca.n > -cb.n:
+				minl = -cb.n
+				ca.n += cb.n
+				cb = next(b.comps, &j)
+			case ca.n == -cb.n:
+				minl = ca.n
+				ca = next(a.comps, &i)
+				cb = next(b.comps, &j)
+			default:
+				minl = ca.n
+				cb.n += ca.n
+				ca = next(a.comps, &i)
+			}
+			bp.Delete(minl)
+		default:
+			return Operation{}, Operation{}, ErrInvalid
+		}
+	}
+
+	return ap, bp, nil
+}
+
+func (o Operation) MarshalJSON() ([]byte, error) {
+	list := make([]interface{}, 0, len(o.comps))
+	for _, c := range o.comps {
+		if c.s != "" {
+			list = append(list, c.s)
+		} else {
+			list = append(list, c.n)
+		}
+	}
+
+	return json.Marshal(list)
+}
+
+func (o *Operation) UnmarshalJSON(data []byte) error {
+	list := []interface{}{}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return err
+	}
+
+	*o = Operation{}
+
+	for _, v := range list {
+		switch v := v.(type) {
+		case string:
+			if utf8.RuneCountInString(v) > MaxLen-o.TargetLen {
+				return ErrInvalid
+			}
+			o.Insert(v)
+		case float64:
+			if v > float64(MaxLen-o.BaseLen) || v < -float64(MaxLen-o.BaseLen) {
+				return ErrInvalid
+			}
+			n := int(v)
+			if float64(n) != v {
+				return ErrInvalid
+			}
+			if n > 0 {
+				if n > MaxLen-o.TargetLen {
+					return ErrInvalid
+				}
+				o.Retain(n)
+			} else {
+				o.Delete(-n)
+			}
+		default:
+			return ErrInvalid
+		}
+	}
+
+	return nil
+}
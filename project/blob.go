@@ -0,0 +1,134 @@
+package project
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+
+	"github.com/frengine/server/delta"
+)
+
+// queryer is what *sql.DB and *sql.Tx have in common.
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// HashContent returns the key revision content is stored under: the hex
+// encoded SHA-256 of it. Revisions with the same content share one blob.
+func HashContent(content string) string {
+	sum := sha256.Sum256([]byte(content))
+	return hex.EncodeToString(sum[:])
+}
+
+// saveBlob stores content if it isn't stored yet, and returns its hash.
+//
+// If the store keeps deltas, the content is stored as a delta against the
+// base blob (normally the content of the parent revision), unless that would
+// make the chain of deltas longer than SnapshotInterval or the delta doesn't
+// save much.
+func (s PostgresStore) saveBlob(tx *sql.Tx, content string, base string) (string, error) {
+	hash := HashContent(content)
+
+	var exists bool
+	err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM blob WHERE hash=$1);`, hash).Scan(&exists)
+	if err != nil || exists {
+		return hash, err
+	}
+
+	if s.SnapshotInterval > 1 && base != "" {
+		var depth int
+		err := tx.QueryRow(`SELECT depth FROM blob WHERE hash=$1;`, base).Scan(&depth)
+		if err != nil {
+			return hash, err
+		}
+
+		if depth+1 < s.SnapshotInterval {
+			baseContent, err := loadBlob(tx, base)
+			if err != nil {
+				return hash, err
+			}
+
+			d := delta.Make([]byte(baseContent), []byte(content))
+			if len(d) < len(content)/2 {
+				_, err := tx.Exec(`INSERT INTO blob (hash, delta, delta_base, depth) VALUES ($1, $2, $3, $4) ON CONFLICT (hash) DO NOTHING;`,
+					hash, d, base, depth+1)
+				return hash, err
+			}
+		}
+	}
+
+	_, err = tx.Exec(`INSERT INTO blob (hash, content) VALUES ($1, $2) ON CONFLICT (hash) DO NOTHING;`, hash, content)
+
+	return hash, err
+}
+
+// loadBlob returns the content of a blob, applying deltas if needed.
+func loadBlob(q queryer, hash string) (string, error) {
+	// Walk from the blob to the full snapshot it's based on, and return the
+	// chain starting at the snapshot.
+	rows, err := q.Query(`WITH RECURSIVE chain (content, delta, delta_base, n) AS (
+		SELECT content, delta, delta_base, 0 FROM blob WHERE hash=$1
+		UNION ALL
+		SELECT blob.content, blob.delta, blob.delta_base, chain.n + 1
+		FROM blob
+		INNER JOIN chain
+			ON blob.hash = chain.delta_base
+	)
+	SELECT content, delta FROM chain ORDER BY n DESC;`, hash)
+	if err != nil {
+		return "", err
+	}
+	defer rows.Close()
+
+	var content []byte
+	found := false
+
+	for rows.Next() {
+		var full sql.NullString
+		var d []byte
+
+		err := rows.Scan(&full, &d)
+		if err != nil {
+			return "", err
+		}
+
+		if !found {
+			if !full.Valid {
+				return "", delta.ErrCorrupt
+			}
+			content = []byte(full.String)
+			found = true
+			continue
+		}
+
+		content, err = delta.Apply(content, d)
+		if err != nil {
+			return "", err
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return "", err
+	}
+
+	if !found {
+		return "", ErrNoFound
+	}
+
+	return string(content), nil
+}
+
+// FetchBlob returns the content stored under hash, if a revision of the
+// project has it. Blobs of other projects are not found.
+func (s PostgresStore) FetchBlob(pid int, hash string) (string, error) {
+	var exists bool
+	err := s.DB.QueryRow(`SELECT EXISTS (SELECT 1 FROM revision WHERE project_id=$1 AND blob_hash=$2);`, pid, hash).Scan(&exists)
+	if err != nil {
+		return "", err
+	}
+	if !exists {
+		return "", ErrNoFound
+	}
+
+	return loadBlob(s.DB, hash)
+}
@@ -0,0 +1,147 @@
+package project
+
+import (
+	"database/sql"
+	"encoding/json"
+	"log"
+	"time"
+
+	"github.com/frengine/server/events"
+	"github.com/lib/pq"
+)
+
+const (
+	listenerMinReconnect = 10 * time.Second
+	listenerMaxReconnect = time.Minute
+	listenerPingInterval = 90 * time.Second
+)
+
+// recordEvent stores the event in the transaction, filling in its ID. It
+// should be published once the transaction is committed.
+func recordEvent(tx *sql.Tx, e *events.Event) error {
+	q := `INSERT INTO event (project_id, type, revision_id, branch, name) VALUES ($1, $2, $3, $4, $5) RETURNING id, created;`
+
+	err := tx.QueryRow(q, e.ProjectID, e.Type, e.RevisionID, e.Branch, e.Name).Scan(&e.ID, &e.Created)
+	if err != nil {
+		return err
+	}
+	e.CreatedUTS = e.Created.Unix()
+
+	return nil
+}
+
+// commitEvent records the event and commits. Postgres then notifies every
+// instance listening, see Listen.
+func (s PostgresStore) commitEvent(tx *sql.Tx, e events.Event) error {
+	err := recordEvent(tx, &e)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`SELECT pg_notify($1, $2);`, events.Channel, string(payload))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Listen publishes the events of every server instance to s.Events, as
+// Postgres notifies about them. It only returns if it can't start listening.
+func (s PostgresStore) Listen(dbString string, logErr *log.Logger) error {
+	var lastID int64
+	err := s.DB.QueryRow(`SELECT COALESCE(MAX(id), 0) FROM event;`).Scan(&lastID)
+	if err != nil {
+		return err
+	}
+
+	l := pq.NewListener(dbString, listenerMinReconnect, listenerMaxReconnect, func(ev pq.ListenerEventType, err error) {
+		if err != nil {
+			logErr.Println(err)
+		}
+	})
+	defer l.Close()
+
+	err = l.Listen(events.Channel)
+	if err != nil {
+		return err
+	}
+
+	ping := time.NewTicker(listenerPingInterval)
+	defer ping.Stop()
+
+	for {
+		select {
+		case n := <-l.Notify:
+			if n == nil {
+				// The connection was lost, so notifications might have been
+				// missed. Subscribers have to cope with seeing some twice.
+				missed, err := s.queryEvents(`SELECT id, project_id, type, revision_id, branch, name, created FROM event WHERE id > $1 ORDER BY id;`, lastID)
+				if err != nil {
+					logErr.Println(err)
+				}
+				for _, e := range missed {
+					s.Events.Publish(e)
+					if e.ID > lastID {
+						lastID = e.ID
+					}
+				}
+				continue
+			}
+
+			e := events.Event{}
+			err := json.Unmarshal([]byte(n.Extra), &e)
+			if err != nil {
+				logErr.Println(err)
+				continue
+			}
+
+			s.Events.Publish(e)
+			if e.ID > lastID {
+				lastID = e.ID
+			}
+		case <-ping.C:
+			go l.Ping()
+		}
+	}
+}
+
+// FetchEventsSince returns the events of the project after the given event
+// ID, oldest first.
+func (s PostgresStore) FetchEventsSince(pid int, after int64) ([]events.Event, error) {
+	q := `SELECT id, project_id, type, revision_id, branch, name, created FROM event WHERE project_id=$1 AND id > $2 ORDER BY id;`
+
+	return s.queryEvents(q, pid, after)
+}
+
+func (s PostgresStore) queryEvents(q string, args ...interface{}) ([]events.Event, error) {
+	rows, err := s.DB.Query(q, args...)
+	if err != nil {
+		return []events.Event{}, err
+	}
+	defer rows.Close()
+
+	es := []events.Event{}
+
+	for rows.Next() {
+		e := events.Event{}
+
+		err := rows.Scan(&e.ID, &e.ProjectID, &e.Type, &e.RevisionID, &e.Branch, &e.Name, &e.Created)
+		if err != nil {
+			return es, err
+		}
+
+		if e.Created != nil {
+			e.CreatedUTS = e.Created.Unix()
+		}
+
+		es = append(es, e)
+	}
+
+	return es, rows.Err()
+}
@@ -0,0 +1,66 @@
+package project
+
+import (
+	"database/sql"
+
+	"github.com/frengine/server/auth"
+	"github.com/lib/pq"
+)
+
+// Fork copies a project with its whole history, branches and tags, to a new
+// project of owner's. The copies share their content with the originals. If
+// name is empty, the fork gets the name of the original.
+func (s PostgresStore) Fork(id int, name string, owner auth.User) (int, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// Lock the project, so no revisions are saved while it's copied.
+	if _, err := lockHead(tx, id, MainBranch); err != nil {
+		return 0, err
+	}
+
+	var fid int
+	err = tx.QueryRow(`INSERT INTO project (name, author_id, visibility, upstream_id, search)
+	SELECT COALESCE(NULLIF($2, ''), name), $3, visibility, id, search FROM project WHERE id = $1
+	RETURNING id;`, id, name, owner.ID).Scan(&fid)
+	if err == sql.ErrNoRows {
+		return 0, ErrNoFound
+	}
+	if err != nil {
+		pqErr, ok := err.(*pq.Error)
+		if ok && pqErr.Code == "23503" {
+			return 0, ErrInvalidAuthor
+		}
+		return 0, err
+	}
+
+	// Every revision gets a new ID up front, so the parents of the copies
+	// can point at the copies of the parents.
+	q := `WITH src AS (
+		SELECT id, nextval(pg_get_serial_sequence('revision', 'id')) AS new_id, blob_hash, parent_id, author_id, message, created
+		FROM revision
+		WHERE project_id = $1
+	), revisions AS (
+		INSERT INTO revision (id, blob_hash, project_id, parent_id, author_id, message, created)
+		SELECT src.new_id, src.blob_hash, $2, parent.new_id, src.author_id, src.message, src.created
+		FROM src
+		LEFT JOIN src AS parent
+			ON parent.id = src.parent_id
+	)
+	INSERT INTO ref (project_id, name, revision_id, tag)
+	SELECT $2, ref.name, src.new_id, ref.tag
+	FROM ref
+	LEFT JOIN src
+		ON src.id = ref.revision_id
+	WHERE ref.project_id = $1;`
+
+	_, err = tx.Exec(q, id, fid)
+	if err != nil {
+		return 0, err
+	}
+
+	return fid, tx.Commit()
+}
@@ -0,0 +1,188 @@
+package project
+
+import (
+	"database/sql"
+	"strconv"
+
+	"github.com/lib/pq"
+)
+
+// maxIndexedContent is how much of the content is indexed for full text
+// search, because Postgres can't index documents of any size.
+const maxIndexedContent = 1 << 19
+
+// indexContent makes content the text a project is found by in SearchContent.
+// It's called whenever the main branch moves.
+func indexContent(tx *sql.Tx, pid int, content string) error {
+	_, err := tx.Exec(`UPDATE project SET search = to_tsvector('simple', left($2, $3)) WHERE id=$1;`, pid, content, maxIndexedContent)
+	return err
+}
+
+// IndexMissing indexes the projects that have content but weren't indexed
+// yet, like those whose content was stored as a delta when search was added.
+// It returns how many it indexed.
+func (s PostgresStore) IndexMissing() (int, error) {
+	q := `SELECT project.id, revision.blob_hash
+	FROM project
+	INNER JOIN ref
+		ON ref.project_id = project.id AND ref.name = 'main' AND NOT ref.tag
+	INNER JOIN revision
+		ON revision.id = ref.revision_id
+	WHERE project.search IS NULL AND revision.blob_hash IS NOT NULL;`
+
+	rows, err := s.DB.Query(q)
+	if err != nil {
+		return 0, err
+	}
+
+	type missing struct {
+		pid  int
+		hash string
+	}
+	ms := []missing{}
+
+	for rows.Next() {
+		m := missing{}
+		if err := rows.Scan(&m.pid, &m.hash); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ms = append(ms, m)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	n := 0
+
+	for _, m := range ms {
+		content, err := loadBlob(s.DB, m.hash)
+		if err != nil {
+			return n, err
+		}
+
+		// Saving in the meantime indexes the project too, and is newer.
+		_, err = s.DB.Exec(`UPDATE project SET search = to_tsvector('simple', left($2, $3)) WHERE id=$1 AND search IS NULL;`, m.pid, content, maxIndexedContent)
+		if err != nil {
+			return n, err
+		}
+
+		n++
+	}
+
+	return n, nil
+}
+
+// headline is the SQL for the parts of the text expression that match the
+// tsquery expression, as HTML. The text is escaped first, like
+// html.EscapeString does, so only the highlighting is HTML.
+func headline(expr string, query string) string {
+	text := `left(` + expr + `, ` + strconv.Itoa(maxIndexedContent) + `)`
+	escaped := `replace(replace(replace(replace(replace(` + text + `, '&', '&amp;'), '<', '&lt;'), '>', '&gt;'), '"', '&#34;'), '''', '&#39;')`
+
+	return `ts_headline('simple', ` + escaped + `, ` + query + `, 'StartSel=<mark>, StopSel=</mark>, MaxFragments=3, FragmentDelimiter=" … "')`
+}
+
+type SearchResult struct {
+	// Project is without the content of its revision, see Snippet instead.
+	Project Project `json:"project"`
+	Rank    float32 `json:"rank"`
+
+	// Snippet is HTML with the parts of the content that matched, which are
+	// highlighted with <mark>.
+	Snippet string `json:"snippet"`
+}
+
+// SearchContent finds the projects listed for the viewer whose latest content
+// matches the query, best matches first. The query is in the syntax of web
+// search engines: words, "quoted phrases", or and -excluded.
+func (s PostgresStore) SearchContent(query string, viewer uint, limit int) ([]SearchResult, error) {
+	// Content stored as a delta isn't there to make a snippet of, see
+	// deltaHeadlines.
+	q := `SELECT ` + projectColumns + `, ` + revisionColumnsNoContent + `, ts_rank(project.search, query) AS rank,
+		blob.delta IS NOT NULL, COALESCE(` + headline(`blob.content`, `query`) + `, '')
+	FROM project
+	CROSS JOIN websearch_to_tsquery('simple', $2) AS query
+	` + projectJoins + `
+	WHERE project.deleted IS NULL AND ` + visibleTo("1", `'public'`) + ` AND project.search @@ query
+	ORDER BY rank DESC, project.id DESC
+	LIMIT $3;`
+
+	rows, err := s.DB.Query(q, viewer, query, limit)
+	if err != nil {
+		return []SearchResult{}, err
+	}
+	defer rows.Close()
+
+	results := []SearchResult{}
+	deltas := []int{}
+
+	for rows.Next() {
+		row := projectRow{}
+		result := SearchResult{}
+		var isDelta bool
+
+		err := rows.Scan(append(row.fields(), &result.Rank, &isDelta, &result.Snippet)...)
+		if err != nil {
+			return results, err
+		}
+
+		result.Project, err = row.project(s.DB)
+		if err != nil {
+			return results, err
+		}
+
+		if isDelta {
+			deltas = append(deltas, len(results))
+		}
+
+		results = append(results, result)
+	}
+	if err := rows.Err(); err != nil {
+		return results, err
+	}
+
+	if len(deltas) > 0 {
+		err = s.deltaHeadlines(results, deltas, query)
+	}
+
+	return results, err
+}
+
+// deltaHeadlines makes the snippets of the results at the indexes, whose
+// content is stored as a delta, in a single query.
+func (s PostgresStore) deltaHeadlines(results []SearchResult, indexes []int, query string) error {
+	contents := make([]string, len(indexes))
+
+	for i, ri := range indexes {
+		rev := results[ri].Project.Revision
+		if rev == nil || rev.Hash == nil {
+			continue
+		}
+
+		content, err := loadBlob(s.DB, *rev.Hash)
+		if err != nil {
+			return err
+		}
+		contents[i] = content
+	}
+
+	q := `SELECT ` + headline(`content`, `websearch_to_tsquery('simple', $2)`) + `
+	FROM unnest($1::text[]) WITH ORDINALITY AS t (content, n)
+	ORDER BY n;`
+
+	rows, err := s.DB.Query(q, pq.Array(contents), query)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for i := 0; i < len(indexes) && rows.Next(); i++ {
+		if err := rows.Scan(&results[indexes[i]].Snippet); err != nil {
+			return err
+		}
+	}
+
+	return rows.Err()
+}
@@ -0,0 +1,181 @@
+package project
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/frengine/server/auth"
+	"github.com/lib/pq"
+)
+
+// Lease is held by the one user allowed to save revisions of a project until
+// it expires.
+type Lease struct {
+	Holder *auth.User `json:"holder"`
+
+	Expires    *time.Time `json:"-"`
+	ExpiresUTS int64      `json:"expires"`
+}
+
+var ErrLeaseHeld = errors.New("project is leased by someone else")
+
+// leaseColumns are the columns scanned by leaseRow, for a query on project
+// that includes leaseJoins. Expired leases are left out.
+const leaseColumns = `lease.expires, lease_holder.id, lease_holder.login`
+
+const leaseJoins = `LEFT JOIN lease
+		ON lease.project_id = project.id AND lease.expires > NOW()
+	LEFT JOIN account AS lease_holder
+		ON lease_holder.id = lease.holder_id`
+
+type leaseRow struct {
+	expires    *time.Time
+	holderID   *uint
+	holderName *string
+}
+
+func (row *leaseRow) fields() []interface{} {
+	return []interface{}{&row.expires, &row.holderID, &row.holderName}
+}
+
+// lease returns nil if the project isn't leased.
+func (row *leaseRow) lease() *Lease {
+	if row.expires == nil || row.holderID == nil {
+		return nil
+	}
+
+	l := &Lease{
+		Holder:     &auth.User{ID: *row.holderID},
+		Expires:    row.expires,
+		ExpiresUTS: row.expires.Unix(),
+	}
+	if row.holderName != nil {
+		l.Holder.Name = *row.holderName
+	}
+
+	return l
+}
+
+// FetchLease returns the lease on the project, or nil if nobody holds one.
+func (s PostgresStore) FetchLease(pid int) (*Lease, error) {
+	q := `SELECT ` + leaseColumns + `
+	FROM lease
+	INNER JOIN account AS lease_holder
+		ON lease_holder.id = lease.holder_id
+	WHERE lease.project_id=$1 AND lease.expires > NOW();`
+
+	row := leaseRow{}
+
+	err := s.DB.QueryRow(q, pid).Scan(row.fields()...)
+	if err == sql.ErrNoRows {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return row.lease(), nil
+}
+
+// checkLease returns ErrLeaseHeld if someone but holder holds a lease on the
+// project. The project has to be locked by the transaction, see lockHead, so
+// nobody can take the lease before it ends.
+func checkLease(tx *sql.Tx, pid int, holder auth.User) error {
+	var held bool
+	err := tx.QueryRow(`SELECT EXISTS (SELECT 1 FROM lease WHERE project_id=$1 AND holder_id <> $2 AND expires > NOW());`, pid, holder.ID).Scan(&held)
+	if err != nil {
+		return err
+	}
+	if held {
+		return ErrLeaseHeld
+	}
+
+	return nil
+}
+
+// AcquireLease gives holder the lease on the project for the duration, unless
+// someone else holds it, in which case ErrLeaseHeld is returned. If holder
+// already holds it, it's renewed.
+func (s PostgresStore) AcquireLease(pid int, holder auth.User, d time.Duration) (Lease, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return Lease{}, err
+	}
+	defer tx.Rollback()
+
+	// Wait for revisions being saved, which checked the lease already.
+	var id int
+	err = tx.QueryRow(`SELECT id FROM project WHERE id=$1 FOR UPDATE;`, pid).Scan(&id)
+	if err == sql.ErrNoRows {
+		return Lease{}, ErrInvalidProject
+	}
+	if err != nil {
+		return Lease{}, err
+	}
+
+	q := `INSERT INTO lease (project_id, holder_id, expires) VALUES ($1, $2, NOW() + $3 * interval '1 second')
+	ON CONFLICT (project_id) DO UPDATE SET holder_id = EXCLUDED.holder_id, expires = EXCLUDED.expires
+	WHERE lease.expires <= NOW() OR lease.holder_id = EXCLUDED.holder_id
+	RETURNING expires, (SELECT login FROM account WHERE id = lease.holder_id);`
+
+	l, err := s.scanLease(tx.QueryRow(q, pid, holder.ID, int(d.Seconds())), holder)
+	if err == ErrNoFound {
+		return l, ErrLeaseHeld
+	}
+	if err != nil {
+		pqErr, ok := err.(*pq.Error)
+		if ok && pqErr.Code == "23503" {
+			return l, ErrInvalidProject
+		}
+		return l, err
+	}
+
+	return l, tx.Commit()
+}
+
+// RenewLease extends the lease holder holds on the project, so it expires
+// after the duration from now. If holder doesn't hold it, ErrNoFound is
+// returned.
+func (s PostgresStore) RenewLease(pid int, holder auth.User, d time.Duration) (Lease, error) {
+	q := `UPDATE lease SET expires = NOW() + $3 * interval '1 second'
+	WHERE project_id=$1 AND holder_id=$2 AND expires > NOW()
+	RETURNING expires, (SELECT login FROM account WHERE id = lease.holder_id);`
+
+	return s.scanLease(s.DB.QueryRow(q, pid, holder.ID, int(d.Seconds())), holder)
+}
+
+func (s PostgresStore) scanLease(row *sql.Row, holder auth.User) (Lease, error) {
+	l := Lease{Holder: &auth.User{ID: holder.ID}}
+
+	err := row.Scan(&l.Expires, &l.Holder.Name)
+	if err == sql.ErrNoRows {
+		return Lease{}, ErrNoFound
+	}
+	if err != nil {
+		return Lease{}, err
+	}
+
+	l.ExpiresUTS = l.Expires.Unix()
+
+	return l, nil
+}
+
+// ReleaseLease gives up the lease holder holds on the project. If holder
+// doesn't hold it, ErrNoFound is returned.
+func (s PostgresStore) ReleaseLease(pid int, holder auth.User) error {
+	result, err := s.DB.Exec(`DELETE FROM lease WHERE project_id=$1 AND holder_id=$2 AND expires > NOW();`, pid, holder.ID)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNoFound
+	}
+
+	return nil
+}
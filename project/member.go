@@ -0,0 +1,190 @@
+package project
+
+import (
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/frengine/server/auth"
+	"github.com/frengine/server/org"
+	"github.com/lib/pq"
+)
+
+// Role says what a member may do with a project. Every role may do what the
+// roles below it may.
+type Role string
+
+const (
+	// RoleOwner can change and delete the project, and manage its members.
+	// The owners of the project's organization, or the author if it has
+	// none, are always owners.
+	RoleOwner Role = "owner"
+	// RoleEditor can save revisions and manage branches and tags. Members of
+	// the project's organization are at least editors.
+	RoleEditor Role = "editor"
+	// RoleViewer can only look.
+	RoleViewer Role = "viewer"
+)
+
+var roleRanks = map[Role]int{
+	RoleViewer: 1,
+	RoleEditor: 2,
+	RoleOwner:  3,
+}
+
+func ValidRole(role Role) bool {
+	return roleRanks[role] > 0
+}
+
+// AtLeast tells whether the role may do what min may. The empty role, of
+// someone who isn't a member, may do nothing.
+func (r Role) AtLeast(min Role) bool {
+	return roleRanks[r] > 0 && roleRanks[r] >= roleRanks[min]
+}
+
+type Member struct {
+	User *auth.User `json:"user"`
+	Role Role       `json:"role"`
+
+	Created    *time.Time `json:"-"`
+	CreatedUTS int64      `json:"created"`
+}
+
+var (
+	ErrInvalidRole = errors.New("invalid role")
+	ErrInvalidUser = errors.New("invalid user")
+)
+
+// FetchRole returns the role the user has in the project, or the empty role
+// if they aren't a member.
+func (s PostgresStore) FetchRole(pid int, uid uint) (Role, error) {
+	q := `SELECT ` + ownedByAuthor("2") + `, COALESCE(project_member.role, ''), COALESCE(organization_member.role, '')
+	FROM project
+	LEFT JOIN project_member
+		ON project_member.project_id = project.id AND project_member.account_id = $2
+	LEFT JOIN organization_member
+		ON organization_member.organization_id = project.organization_id AND organization_member.account_id = $2
+	WHERE project.id=$1;`
+
+	var isAuthor bool
+	var role Role
+	var orgRole org.Role
+
+	err := s.DB.QueryRow(q, pid, uid).Scan(&isAuthor, &role, &orgRole)
+	if err == sql.ErrNoRows {
+		return "", ErrNoFound
+	}
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case isAuthor, orgRole == org.RoleOwner:
+		return RoleOwner, nil
+	case orgRole == org.RoleMember && !role.AtLeast(RoleEditor):
+		return RoleEditor, nil
+	}
+
+	return role, nil
+}
+
+// FetchMembers returns the author, as owner, followed by everyone else who
+// was added to the project. Projects of an organization don't list the
+// author, as the organization owns them.
+func (s PostgresStore) FetchMembers(pid int) ([]Member, error) {
+	q := `SELECT account.id, account.login, 'owner', project.created, 0
+	FROM project
+	INNER JOIN account
+		ON account.id = project.author_id
+	WHERE project.id=$1 AND project.organization_id IS NULL
+	UNION ALL
+	SELECT account.id, account.login, project_member.role, project_member.created, 1
+	FROM project_member
+	INNER JOIN account
+		ON account.id = project_member.account_id
+	INNER JOIN project
+		ON project.id = project_member.project_id
+	WHERE project_member.project_id=$1 AND (project.organization_id IS NOT NULL OR project_member.account_id <> project.author_id)
+	ORDER BY 5, 2;`
+
+	rows, err := s.DB.Query(q, pid)
+	if err != nil {
+		return []Member{}, err
+	}
+	defer rows.Close()
+
+	ms := []Member{}
+
+	for rows.Next() {
+		m := Member{User: &auth.User{}}
+		var order int
+
+		err := rows.Scan(&m.User.ID, &m.User.Name, &m.Role, &m.Created, &order)
+		if err != nil {
+			return ms, err
+		}
+
+		if m.Created != nil {
+			m.CreatedUTS = m.Created.Unix()
+		}
+
+		ms = append(ms, m)
+	}
+
+	return ms, rows.Err()
+}
+
+func (s PostgresStore) AddMember(pid int, uid uint, role Role) error {
+	if !ValidRole(role) {
+		return ErrInvalidRole
+	}
+
+	_, err := s.DB.Exec(`INSERT INTO project_member (project_id, account_id, role) VALUES ($1, $2, $3);`, pid, uid, role)
+	if err != nil {
+		pqErr, ok := err.(*pq.Error)
+		if ok && pqErr.Code == "23505" {
+			return ErrAlreadyExists
+		}
+		if ok && pqErr.Code == "23503" {
+			return ErrInvalidUser
+		}
+		return err
+	}
+
+	return nil
+}
+
+func (s PostgresStore) UpdateMember(pid int, uid uint, role Role) error {
+	if !ValidRole(role) {
+		return ErrInvalidRole
+	}
+
+	result, err := s.DB.Exec(`UPDATE project_member SET role = $3 WHERE project_id=$1 AND account_id=$2;`, pid, uid, role)
+	if err != nil {
+		return err
+	}
+
+	return expectRows(result)
+}
+
+func (s PostgresStore) RemoveMember(pid int, uid uint) error {
+	result, err := s.DB.Exec(`DELETE FROM project_member WHERE project_id=$1 AND account_id=$2;`, pid, uid)
+	if err != nil {
+		return err
+	}
+
+	return expectRows(result)
+}
+
+// expectRows returns ErrNoFound if nothing was affected.
+func expectRows(result sql.Result) error {
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNoFound
+	}
+
+	return nil
+}
@@ -4,43 +4,89 @@ import (
 	"database/sql"
 	"errors"
 	"time"
-	"sort"
 
 	"github.com/frengine/server/auth"
-	"github.com/lib/pq"
+	"github.com/frengine/server/events"
+	"github.com/frengine/server/org"
 )
 
 type Project struct {
-	ID         int        `json:"id"`
-	Name       string     `json:"name"`
-	Author     *auth.User `json:"author,omitempty"`
+	ID     int        `json:"id"`
+	Name   string     `json:"name"`
+	Author *auth.User `json:"author,omitempty"`
+
+	// Organization owns the project instead of the author, if set.
+	Organization *org.Organization `json:"organization,omitempty"`
+
+	// Upstream is the ID of the project this one was forked from.
+	Upstream *int `json:"upstream,omitempty"`
+
+	// Template projects are offered as a start for new projects.
+	Template bool `json:"template"`
+
 	Modtime    *time.Time `json:"-"`
 	ModtimeUTS int64      `json:"modtime"`
 	Created    *time.Time `json:"-"`
 	CreatedUTS int64      `json:"created"`
 
+	// Visibility is one of VisibilityPublic, VisibilityUnlisted or
+	// VisibilityPrivate.
+	Visibility string `json:"visibility"`
+
 	Revision *Revision `json:"revision"`
 
+	// Lease is nil unless someone holds an edit lease on the project.
+	Lease *Lease `json:"lease"`
+
 	TouchedUTS int64 `json:"touched"`
 
-	Deleted *time.Time `json:"-"`
+	// Deleted is set for projects in the trash.
+	Deleted    *time.Time `json:"-"`
+	DeletedUTS int64      `json:"deleted,omitempty"`
 }
 
-type ProjectSlice []Project
+const (
+	// VisibilityPublic projects are listed for everyone.
+	VisibilityPublic = "public"
+	// VisibilityUnlisted projects can be seen by anyone knowing their ID, but
+	// are only listed for their author and members.
+	VisibilityUnlisted = "unlisted"
+	// VisibilityPrivate projects can only be seen by their author and
+	// members.
+	VisibilityPrivate = "private"
+)
 
-func (s ProjectSlice) Len() int {
-	return len(s)
+func ValidVisibility(visibility string) bool {
+	switch visibility {
+	case VisibilityPublic, VisibilityUnlisted, VisibilityPrivate:
+		return true
+	}
+	return false
 }
 
-func (s ProjectSlice) Less(i, j int) bool {
-	return s[i].TouchedUTS > s[j].TouchedUTS
+// ownedByAuthor is the condition for the account in parameter n to own a
+// project in a query as its author, which it doesn't if an organization owns
+// the project.
+func ownedByAuthor(n string) string {
+	return `(project.organization_id IS NULL AND project.author_id = $` + n + `)`
 }
 
-func (s ProjectSlice) Swap(i, j int) {
-	cp := s[i]
+// visibleTo is the condition for a project in a query to be visible to the
+// account in parameter n, listing only those with visibility in list unless
+// the account owns the project or is a member of it or its organization.
+// Anonymous viewers have ID 0.
+func visibleTo(n string, list string) string {
+	return `(project.visibility IN (` + list + `) OR ` + ownedByAuthor(n) + ` OR EXISTS (
+		SELECT 1 FROM project_member WHERE project_member.project_id = project.id AND project_member.account_id = $` + n + `
+	) OR EXISTS (
+		SELECT 1 FROM organization_member WHERE organization_member.organization_id = project.organization_id AND organization_member.account_id = $` + n + `
+	))`
+}
 
-	s[i] = s[j]
-	s[j] = cp
+// AuthorOwns tells whether the author owns the project, which they don't if
+// an organization does.
+func (p Project) AuthorOwns() bool {
+	return p.Organization == nil
 }
 
 func (p Project) LastModified() time.Time {
@@ -56,18 +102,72 @@ func (p Project) LastModified() time.Time {
 }
 
 type Store interface {
-	Search() ([]Project, error)
-	FetchByID(id int) (Project, error)
-	Create(name string, author auth.User) (int, error)
+	// Search and FetchByID only return projects visible to the viewer, which
+	// is 0 for anonymous users. Search returns a cursor for the next page,
+	// or "" on the last one.
+	Search(opts SearchOptions) ([]Project, string, error)
+	FetchByID(id int, viewer uint) (Project, error)
+	SearchContent(query string, viewer uint, limit int) ([]SearchResult, error)
+	Create(p Project) (int, error)
+	Fork(id int, name string, owner auth.User) (int, error)
 	Update(p Project) error
 	Delete(id int) error
 
-	SaveRevision(pid int, content string) error
+	ProposeTransfer(pid int, to auth.User) (int, error)
+	FetchTransfers(uid uint) ([]Transfer, error)
+	AcceptTransfer(id int, uid uint) error
+	DeclineTransfer(id int, uid uint) error
+	CancelTransfer(pid int) error
+	Move(pid int, oid int, author auth.User) error
+
+	FetchTrash(uid uint) ([]Project, error)
+	Undelete(id int) error
+	Purge(id int) error
+	PurgeDeletedBefore(t time.Time) (int, error)
+
+	SaveRevision(pid int, branch string, parent *int, author auth.User, message string, content string) (int, error)
 	FetchLatestRevisionByProject(pid int) (Revision, error)
+	FetchRevisionsByProject(pid int, branch string, offset int, limit int, withContent bool) ([]Revision, error)
+	FetchRevision(pid int, rid int) (Revision, error)
+	EachRevision(pid int, fn func(Revision) error) error
+	FetchBlob(pid int, hash string) (string, error)
+	FetchEventsSince(pid int, after int64) ([]events.Event, error)
+	RestoreRevision(pid int, rid int, author auth.User) (int, error)
+
+	FetchRole(pid int, uid uint) (Role, error)
+	FetchMembers(pid int) ([]Member, error)
+	AddMember(pid int, uid uint, role Role) error
+	UpdateMember(pid int, uid uint, role Role) error
+	RemoveMember(pid int, uid uint) error
+
+	FetchLease(pid int) (*Lease, error)
+	AcquireLease(pid int, holder auth.User, d time.Duration) (Lease, error)
+	RenewLease(pid int, holder auth.User, d time.Duration) (Lease, error)
+	ReleaseLease(pid int, holder auth.User) error
+
+	FetchBranchHead(pid int, branch string) (Revision, error)
+	FetchBranches(pid int) ([]Ref, error)
+	CreateBranch(pid int, name string, rid int) error
+	DeleteBranch(pid int, name string) error
+
+	FetchRevisionByRef(pid int, name string) (Revision, error)
+	FetchTags(pid int) ([]Ref, error)
+	FetchTag(pid int, name string) (Ref, error)
+	CreateTag(pid int, name string, rid int) error
+	DeleteTag(pid int, name string) error
 }
 
 type PostgresStore struct {
 	DB *sql.DB
+
+	// SnapshotInterval makes revision content get stored as deltas against
+	// the previous revision, with a full snapshot at least every so many
+	// revisions. 0 stores every revision in full.
+	SnapshotInterval int
+
+	// Events gets every change to a project made by any server instance,
+	// while Listen runs. It can be nil.
+	Events *events.Bus
 }
 
 var (
@@ -76,81 +176,43 @@ var (
 	ErrInvalidAuthor = errors.New("invalid author")
 )
 
-func (s PostgresStore) Search() ([]Project, error) {
-	q := `
-	SELECT project.id, project.name, project.modtime, project.created, account.id, account.login, revision.id, revision.content, revision.created
-	FROM project
-	INNER JOIN account
+// projectColumns are the columns scanned by projectRow, followed by those of
+// the revision. The query has to include projectJoins.
+const projectColumns = `project.id, project.name, project.modtime, project.created, project.deleted, project.visibility, project.upstream_id, project.template, account.id, account.login, organization.id, organization.name, ` + leaseColumns
+
+const projectJoins = `INNER JOIN account
 		ON project.author_id = account.id
+	LEFT JOIN organization
+		ON organization.id = project.organization_id
+	LEFT JOIN ref
+		ON ref.project_id = project.id AND ref.name = 'main' AND NOT ref.tag
 	LEFT JOIN revision
-		ON revision.id = (SELECT id FROM revision WHERE project_id = project.id ORDER BY created DESC LIMIT 1)
-	WHERE project.deleted IS NULL`
-
-	rows, err := s.DB.Query(q)
-	if err != nil {
-		if err == sql.ErrNoRows {
-			return []Project{}, ErrNoFound
-		}
-		return []Project{}, err
-	}
+		ON revision.id = ref.revision_id
+	` + revisionJoins + `
+	` + leaseJoins
 
-	ps := []Project{}
+type projectRow struct {
+	p Project
+	u auth.User
 
-	for rows.Next() {
-		p := Project{}
-		u := auth.User{}
-		r := Revision{}
+	orgID   *int
+	orgName *string
 
-		err := rows.Scan(&p.ID, &p.Name, &p.Modtime, &p.Created, &u.ID, &u.Name, &r.ID, &r.Content, &r.Created)
-		if err != nil {
-			return ps, err
-		}
-
-		if p.Modtime != nil {
-			p.ModtimeUTS = p.Modtime.Unix()
-		}
-		if p.Created != nil {
-			p.CreatedUTS = p.Created.Unix()
-		}
-		if r.Created != nil {
-			r.CreatedUTS = r.Created.Unix()
-		}
-
-		p.CreatedUTS = p.Created.Unix()
-		p.TouchedUTS = max(p.ModtimeUTS, r.CreatedUTS, p.CreatedUTS)
-
-		p.Author = &u
-
-		p.Revision = &r
-
-		ps = append(ps, p)
-	}
-
-	sort.Sort(ProjectSlice(ps))
-
-	return ps, rows.Err()
+	lease    leaseRow
+	revision revisionRow
 }
 
-func (s PostgresStore) FetchByID(id int) (Project, error) {
-	q := `SELECT project.id, project.name, project.modtime, project.created, account.id, account.login, revision.id, revision.content, revision.created
-	FROM project
-	INNER JOIN account
-		ON project.author_id = account.id
-	LEFT JOIN revision
-		ON revision.id = (SELECT id FROM revision WHERE project_id = project.id ORDER BY created DESC LIMIT 1)
-	WHERE project.id=$1 AND deleted IS NULL;`
-
-	p := Project{}
-	u := auth.User{}
-	r := Revision{}
+func (row *projectRow) fields() []interface{} {
+	fields := []interface{}{&row.p.ID, &row.p.Name, &row.p.Modtime, &row.p.Created, &row.p.Deleted, &row.p.Visibility, &row.p.Upstream, &row.p.Template, &row.u.ID, &row.u.Name, &row.orgID, &row.orgName}
+	fields = append(fields, row.lease.fields()...)
+	return append(fields, row.revision.fields()...)
+}
 
-	row := s.DB.QueryRow(q, id)
+func (row *projectRow) project(q queryer) (Project, error) {
+	p := row.p
 
-	err := row.Scan(&p.ID, &p.Name, &p.Modtime, &p.Created, &u.ID, &u.Name, &r.ID, &r.Content, &r.Created)
+	r, err := row.revision.revision(q)
 	if err != nil {
-		if err == sql.ErrNoRows {
-			return p, ErrNoFound
-		}
 		return p, err
 	}
 
@@ -160,18 +222,45 @@ func (s PostgresStore) FetchByID(id int) (Project, error) {
 	if p.Created != nil {
 		p.CreatedUTS = p.Created.Unix()
 	}
-	if r.Created != nil {
-		r.CreatedUTS = r.Created.Unix()
+	if p.Deleted != nil {
+		p.DeletedUTS = p.Deleted.Unix()
 	}
 
-	p.CreatedUTS = p.Created.Unix()
 	p.TouchedUTS = max(p.ModtimeUTS, r.CreatedUTS, p.CreatedUTS)
 
+	u := row.u
 	p.Author = &u
 
+	if row.orgID != nil {
+		p.Organization = &org.Organization{ID: *row.orgID}
+		if row.orgName != nil {
+			p.Organization.Name = *row.orgName
+		}
+	}
+
 	p.Revision = &r
+	p.Lease = row.lease.lease()
 
-	return p, err
+	return p, nil
+}
+
+func (s PostgresStore) FetchByID(id int, viewer uint) (Project, error) {
+	q := `SELECT ` + projectColumns + `, ` + revisionColumns + `
+	FROM project
+	` + projectJoins + `
+	WHERE project.id=$1 AND deleted IS NULL AND ` + visibleTo("2", `'public', 'unlisted'`) + `;`
+
+	row := projectRow{}
+
+	err := s.DB.QueryRow(q, id, viewer).Scan(row.fields()...)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return Project{}, ErrNoFound
+		}
+		return Project{}, err
+	}
+
+	return row.project(s.DB)
 }
 
 func max(nums ...int64) int64 {
@@ -186,32 +275,80 @@ func max(nums ...int64) int64 {
 	return max
 }
 
-func (s PostgresStore) Create(name string, author auth.User) (int, error) {
+// Create makes a new project with the name, author, organization and
+// visibility of p. The visibility is public if left empty. If p has a
+// revision with content, it's saved as the first revision on main.
+func (s PostgresStore) Create(p Project) (int, error) {
 	// TODO: Make these prepared statements.
 
+	var oid int
+	if p.Organization != nil {
+		oid = p.Organization.ID
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
 	var id int
-	err := s.DB.QueryRow(`INSERT INTO project (name, author_id) VALUES ($1, $2) RETURNING id;`, name, author.ID).Scan(&id)
+	err = tx.QueryRow(`INSERT INTO project (name, author_id, visibility, organization_id, template) VALUES ($1, $2, COALESCE(NULLIF($3, ''), 'public'), NULLIF($4, 0), $5) RETURNING id;`,
+		p.Name, p.Author.ID, p.Visibility, oid, p.Template).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	if p.Revision == nil || p.Revision.Content == nil {
+		return id, tx.Commit()
+	}
+
+	message := ""
+	if p.Revision.Message != nil {
+		message = *p.Revision.Message
+	}
 
-	return id, err
+	rid, err := s.insertRevision(tx, id, MainBranch, 0, *p.Author, message, *p.Revision.Content)
+	if err != nil {
+		return 0, err
+	}
+
+	branch := MainBranch
+	return id, s.commitEvent(tx, events.Event{ProjectID: id, Type: events.TypeRevision, RevisionID: &rid, Branch: &branch})
 }
 
+// Update saves the name, visibility and template flag of p. The author only
+// changes through a transfer.
 func (s PostgresStore) Update(p Project) error {
-	q := `UPDATE project SET name = $2, author_id = $3, modtime = NOW() WHERE id = $1;`
+	q := `UPDATE project SET name = $2, visibility = $3, template = $4, modtime = NOW() WHERE id = $1;`
 
-	_, err := s.DB.Exec(q, p.ID, p.Name, p.Author.ID)
+	tx, err := s.DB.Begin()
 	if err != nil {
-		pqErr := err.(*pq.Error)
-		if pqErr.Code == "23503" {
-			return ErrInvalidAuthor
-		}
+		return err
 	}
+	defer tx.Rollback()
 
-	return err
+	_, err = tx.Exec(q, p.ID, p.Name, p.Visibility, p.Template)
+	if err != nil {
+		return err
+	}
+
+	return s.commitEvent(tx, events.Event{ProjectID: p.ID, Type: events.TypeUpdate, Name: &p.Name})
 }
 
 func (s PostgresStore) Delete(id int) error {
 	q := `UPDATE project SET deleted = NOW() WHERE id = $1;`
 
-	_, err := s.DB.Exec(q, id)
-	return err
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.Exec(q, id)
+	if err != nil {
+		return err
+	}
+
+	return s.commitEvent(tx, events.Event{ProjectID: id, Type: events.TypeDelete})
 }
@@ -0,0 +1,250 @@
+package project
+
+import (
+	"database/sql"
+	"errors"
+	"regexp"
+	"time"
+
+	"github.com/lib/pq"
+)
+
+// MainBranch is the branch every project has. Saving without naming a branch
+// saves onto it, and it's what FetchLatestRevisionByProject returns.
+const MainBranch = "main"
+
+type Ref struct {
+	Name       string `json:"name"`
+	RevisionID *int   `json:"revision"`
+
+	Created    *time.Time `json:"-"`
+	CreatedUTS int64      `json:"created"`
+}
+
+var (
+	ErrInvalidRefName = errors.New("invalid ref name")
+	ErrMainBranch     = errors.New("cannot delete the main branch")
+	ErrEmptyProject   = errors.New("project has no revisions")
+)
+
+var refNameRegexp = regexp.MustCompile(`^[A-Za-z0-9._-]{1,255}$`)
+
+func ValidRefName(name string) bool {
+	return refNameRegexp.MatchString(name)
+}
+
+// lockHead locks the project row until the end of the transaction, so no
+// other revision can be saved in the meantime, and returns the ID of the
+// revision the branch points at, or 0 if there is none. Only the main branch
+// exists without having been created.
+func lockHead(tx *sql.Tx, pid int, branch string) (int, error) {
+	var id int
+	err := tx.QueryRow(`SELECT id FROM project WHERE id=$1 FOR UPDATE;`, pid).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, ErrInvalidProject
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	var head sql.NullInt64
+	err = tx.QueryRow(`SELECT revision_id FROM ref WHERE project_id=$1 AND name=$2 AND NOT tag;`, pid, branch).Scan(&head)
+	if err == sql.ErrNoRows {
+		if branch == MainBranch {
+			return 0, nil
+		}
+		return 0, ErrNoFound
+	}
+
+	return int(head.Int64), err
+}
+
+func setHead(tx *sql.Tx, pid int, branch string, rid int) error {
+	q := `INSERT INTO ref (project_id, name, revision_id) VALUES ($1, $2, $3)
+	ON CONFLICT (project_id, name) DO UPDATE SET revision_id = EXCLUDED.revision_id;`
+
+	_, err := tx.Exec(q, pid, branch, rid)
+	return err
+}
+
+func (s PostgresStore) FetchBranchHead(pid int, branch string) (Revision, error) {
+	return s.fetchRefRevision(pid, branch, `AND NOT ref.tag`)
+}
+
+// FetchRevisionByRef returns the revision a branch or tag points at.
+func (s PostgresStore) FetchRevisionByRef(pid int, name string) (Revision, error) {
+	return s.fetchRefRevision(pid, name, ``)
+}
+
+func (s PostgresStore) fetchRefRevision(pid int, name string, cond string) (Revision, error) {
+	q := `SELECT ` + revisionColumns + `
+	FROM ref
+	LEFT JOIN revision
+		ON revision.id = ref.revision_id
+	` + revisionJoins + `
+	WHERE ref.project_id=$1 AND ref.name=$2 ` + cond + `;`
+
+	row := revisionRow{}
+
+	err := s.DB.QueryRow(q, pid, name).Scan(row.fields()...)
+	if err == sql.ErrNoRows {
+		return Revision{}, ErrNoFound
+	}
+	if err != nil {
+		return Revision{}, err
+	}
+
+	return row.revision(s.DB)
+}
+
+func (s PostgresStore) FetchBranches(pid int) ([]Ref, error) {
+	return s.fetchRefs(pid, false)
+}
+
+func (s PostgresStore) FetchTags(pid int) ([]Ref, error) {
+	return s.fetchRefs(pid, true)
+}
+
+func (s PostgresStore) fetchRefs(pid int, tag bool) ([]Ref, error) {
+	q := `SELECT name, revision_id, created FROM ref WHERE project_id=$1 AND tag=$2 ORDER BY name;`
+
+	rows, err := s.DB.Query(q, pid, tag)
+	if err != nil {
+		return []Ref{}, err
+	}
+	defer rows.Close()
+
+	refs := []Ref{}
+
+	for rows.Next() {
+		ref := Ref{}
+
+		err := rows.Scan(&ref.Name, &ref.RevisionID, &ref.Created)
+		if err != nil {
+			return refs, err
+		}
+
+		if ref.Created != nil {
+			ref.CreatedUTS = ref.Created.Unix()
+		}
+
+		refs = append(refs, ref)
+	}
+
+	return refs, rows.Err()
+}
+
+func (s PostgresStore) FetchTag(pid int, name string) (Ref, error) {
+	q := `SELECT name, revision_id, created FROM ref WHERE project_id=$1 AND name=$2 AND tag;`
+
+	ref := Ref{}
+
+	err := s.DB.QueryRow(q, pid, name).Scan(&ref.Name, &ref.RevisionID, &ref.Created)
+	if err == sql.ErrNoRows {
+		return ref, ErrNoFound
+	}
+	if err != nil {
+		return ref, err
+	}
+	if ref.Created != nil {
+		ref.CreatedUTS = ref.Created.Unix()
+	}
+
+	return ref, nil
+}
+
+// CreateBranch makes a new branch pointing at revision rid, or at the head of
+// main if rid is 0.
+func (s PostgresStore) CreateBranch(pid int, name string, rid int) error {
+	return s.createRef(pid, name, rid, false)
+}
+
+// CreateTag makes a new tag pointing at revision rid, or at the head of main
+// if rid is 0. Tags can't be moved afterwards, only deleted.
+func (s PostgresStore) CreateTag(pid int, name string, rid int) error {
+	return s.createRef(pid, name, rid, true)
+}
+
+func (s PostgresStore) createRef(pid int, name string, rid int, tag bool) error {
+	if !ValidRefName(name) {
+		return ErrInvalidRefName
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	head, err := lockHead(tx, pid, MainBranch)
+	if err != nil {
+		return err
+	}
+
+	if rid == 0 {
+		rid = head
+	} else {
+		err := tx.QueryRow(`SELECT id FROM revision WHERE project_id=$1 AND id=$2;`, pid, rid).Scan(&rid)
+		if err == sql.ErrNoRows {
+			return ErrNoFound
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	if tag && rid == 0 {
+		return ErrEmptyProject
+	}
+
+	_, err = tx.Exec(`INSERT INTO ref (project_id, name, revision_id, tag) VALUES ($1, $2, NULLIF($3, 0), $4);`, pid, name, rid, tag)
+	if err != nil {
+		pqErr, ok := err.(*pq.Error)
+		if ok && pqErr.Code == "23505" {
+			return ErrAlreadyExists
+		}
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s PostgresStore) DeleteBranch(pid int, name string) error {
+	if name == MainBranch {
+		return ErrMainBranch
+	}
+
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	if _, err := lockHead(tx, pid, name); err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM ref WHERE project_id=$1 AND name=$2;`, pid, name)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func (s PostgresStore) DeleteTag(pid int, name string) error {
+	result, err := s.DB.Exec(`DELETE FROM ref WHERE project_id=$1 AND name=$2 AND tag;`, pid, name)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rows == 0 {
+		return ErrNoFound
+	}
+
+	return nil
+}
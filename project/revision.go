@@ -3,46 +3,332 @@ package project
 import (
 	"database/sql"
 	"errors"
+	"fmt"
 	"time"
 
+	"github.com/frengine/server/auth"
+	"github.com/frengine/server/events"
 	"github.com/lib/pq"
 )
 
 type Revision struct {
-	ID      *int    `json:"id"`
-	Content *string `json:"content"`
+	ID       *int       `json:"id"`
+	ParentID *int       `json:"parent"`
+	Author   *auth.User `json:"author,omitempty"`
+	Message  *string    `json:"message"`
+	Hash     *string    `json:"hash"`
+	Content  *string    `json:"content"`
 
 	Created    *time.Time `json:"-"`
 	CreatedUTS int64      `json:"created"`
 }
 
+// revisionColumns are the columns scanned by revisionRow. The query has to
+// include revisionJoins.
+const revisionColumns = `revision.id, revision.parent_id, revision.message, revision.blob_hash, blob.content, blob.delta IS NOT NULL, revision.created, revision_author.id, revision_author.login`
+
+// revisionColumnsNoContent is like revisionColumns, but leaves the content
+// out.
+const revisionColumnsNoContent = `revision.id, revision.parent_id, revision.message, revision.blob_hash, NULL, false, revision.created, revision_author.id, revision_author.login`
+
+const revisionJoins = `LEFT JOIN blob
+		ON blob.hash = revision.blob_hash
+	LEFT JOIN account AS revision_author
+		ON revision_author.id = revision.author_id`
+
+// revisionRow scans a revision from a row that might not have one, like when
+// it's left joined.
+type revisionRow struct {
+	r Revision
+
+	// isDelta is set when the content is stored as a delta, and has to be
+	// loaded separately.
+	isDelta *bool
+
+	authorID   *uint
+	authorName *string
+}
+
+func (row *revisionRow) fields() []interface{} {
+	return []interface{}{&row.r.ID, &row.r.ParentID, &row.r.Message, &row.r.Hash, &row.r.Content, &row.isDelta, &row.r.Created, &row.authorID, &row.authorName}
+}
+
+func (row *revisionRow) revision(q queryer) (Revision, error) {
+	r := row.r
+
+	if row.isDelta != nil && *row.isDelta && r.Hash != nil {
+		content, err := loadBlob(q, *r.Hash)
+		if err != nil {
+			return r, err
+		}
+		r.Content = &content
+	}
+
+	if r.Created != nil {
+		r.CreatedUTS = r.Created.Unix()
+	}
+
+	if row.authorID != nil {
+		r.Author = &auth.User{ID: *row.authorID}
+		if row.authorName != nil {
+			r.Author.Name = *row.authorName
+		}
+	}
+
+	return r, nil
+}
+
 var (
 	ErrInvalidProject = errors.New("invalid project")
+	ErrConflict       = errors.New("revision is not based on the latest revision")
 )
 
-func (s PostgresStore) SaveRevision(pid int, content string) error {
-	_, err := s.DB.Exec(`INSERT INTO revision (content, project_id) VALUES ($1, $2);`, content, pid)
+// SaveRevision stores content as the newest revision on the branch and
+// returns its ID. If parent is not nil, it must be the ID of the revision the
+// branch points at (or 0 if there are none yet), otherwise ErrConflict is
+// returned and nothing is saved. The message is optional. If someone but the
+// author holds a lease on the project, ErrLeaseHeld is returned.
+func (s PostgresStore) SaveRevision(pid int, branch string, parent *int, author auth.User, message string, content string) (int, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	head, err := lockHead(tx, pid, branch)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := checkLease(tx, pid, author); err != nil {
+		return 0, err
+	}
+
+	if parent != nil && *parent != head {
+		return 0, ErrConflict
+	}
+
+	id, err := s.insertRevision(tx, pid, branch, head, author, message, content)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, s.commitEvent(tx, events.Event{ProjectID: pid, Type: events.TypeRevision, RevisionID: &id, Branch: &branch})
+}
+
+// insertRevision saves content as a new revision on top of head, which the
+// branch is moved to.
+func (s PostgresStore) insertRevision(tx *sql.Tx, pid int, branch string, head int, author auth.User, message string, content string) (int, error) {
+	var base string
+	if head != 0 {
+		err := tx.QueryRow(`SELECT COALESCE(blob_hash, '') FROM revision WHERE id=$1;`, head).Scan(&base)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	hash, err := s.saveBlob(tx, content, base)
+	if err != nil {
+		return 0, err
+	}
+
+	var id int
+	err = tx.QueryRow(`INSERT INTO revision (blob_hash, project_id, parent_id, author_id, message) VALUES ($1, $2, NULLIF($3, 0), $4, NULLIF($5, '')) RETURNING id;`,
+		hash, pid, head, author.ID, message).Scan(&id)
+	if err != nil {
+		pqErr, ok := err.(*pq.Error)
+		if ok && pqErr.Code == "23503" {
+			return 0, ErrInvalidProject
+		}
+		return 0, err
+	}
+
+	err = setHead(tx, pid, branch, id)
 	if err != nil {
-		pqErr := err.(*pq.Error)
-		if pqErr.Code == "23503" {
-			return ErrInvalidProject
+		return 0, err
+	}
+
+	if branch == MainBranch {
+		err = indexContent(tx, pid, content)
+		if err != nil {
+			return 0, err
 		}
 	}
-	return err
+
+	return id, nil
 }
 
+// FetchLatestRevisionByProject returns the head of the main branch, or an
+// empty revision if nothing has been saved yet.
 func (s PostgresStore) FetchLatestRevisionByProject(pid int) (Revision, error) {
-	q := "SELECT id, content, created FROM revision WHERE project_id=$1 ORDER BY created DESC LIMIT 1;"
+	r, err := s.FetchBranchHead(pid, MainBranch)
+	if err == ErrNoFound {
+		return Revision{}, nil
+	}
 
-	row := s.DB.QueryRow(q, pid)
+	return r, err
+}
+
+// FetchRevisionsByProject returns the history of the branch, newest first:
+// the revision it points at, its parent, and so on. A branch that doesn't
+// exist has no history.
+func (s PostgresStore) FetchRevisionsByProject(pid int, branch string, offset int, limit int, withContent bool) ([]Revision, error) {
+	columns := revisionColumns
+	if !withContent {
+		columns = revisionColumnsNoContent
+	}
 
-	r := Revision{}
+	// Only walk as far back as the page goes.
+	q := `WITH RECURSIVE history (id, n) AS (
+		SELECT revision_id, 0 FROM ref WHERE project_id=$1 AND name=$2 AND NOT tag
+		UNION ALL
+		SELECT revision.parent_id, history.n + 1
+		FROM history
+		INNER JOIN revision
+			ON revision.id = history.id
+		WHERE revision.parent_id IS NOT NULL AND history.n + 1 < $3::integer + $4::integer
+	)
+	SELECT ` + columns + `
+	FROM history
+	INNER JOIN revision
+		ON revision.id = history.id
+	` + revisionJoins + `
+	ORDER BY history.n
+	OFFSET $3 LIMIT $4;`
+
+	rows, err := s.DB.Query(q, pid, branch, offset, limit)
+	if err != nil {
+		return []Revision{}, err
+	}
+	defer rows.Close()
 
-	err := row.Scan(&r.ID, &r.Content, &r.Created)
+	rs := []Revision{}
+
+	for rows.Next() {
+		row := revisionRow{}
+
+		err := rows.Scan(row.fields()...)
+		if err != nil {
+			return rs, err
+		}
+
+		rev, err := row.revision(s.DB)
+		if err != nil {
+			return rs, err
+		}
+
+		rs = append(rs, rev)
+	}
+
+	return rs, rows.Err()
+}
+
+// EachRevision calls fn with every revision of the project, oldest first,
+// until it returns an error. The revisions are fetched one at a time, so no
+// connection is held while fn runs.
+func (s PostgresStore) EachRevision(pid int, fn func(Revision) error) error {
+	rows, err := s.DB.Query(`SELECT id FROM revision WHERE project_id=$1 ORDER BY created, id;`, pid)
+	if err != nil {
+		return err
+	}
+
+	ids := []int{}
+
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		rev, err := s.FetchRevision(pid, id)
+		if err != nil {
+			return err
+		}
+
+		err = fn(rev)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (s PostgresStore) FetchRevision(pid int, rid int) (Revision, error) {
+	q := `SELECT ` + revisionColumns + `
+	FROM revision
+	` + revisionJoins + `
+	WHERE revision.project_id=$1 AND revision.id=$2;`
+
+	row := revisionRow{}
+
+	err := s.DB.QueryRow(q, pid, rid).Scan(row.fields()...)
 	if err == sql.ErrNoRows {
-		return r, nil
+		return Revision{}, ErrNoFound
+	}
+	if err != nil {
+		return Revision{}, err
 	}
-	r.CreatedUTS = r.Created.Unix()
 
-	return r, err
+	return row.revision(s.DB)
+}
+
+// RestoreRevision saves a copy of an older revision as the newest one on the
+// main branch, and returns the ID of the copy. Like SaveRevision, it returns
+// ErrLeaseHeld if someone else holds a lease on the project.
+func (s PostgresStore) RestoreRevision(pid int, rid int, author auth.User) (int, error) {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	head, err := lockHead(tx, pid, MainBranch)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := checkLease(tx, pid, author); err != nil {
+		return 0, err
+	}
+
+	q := `INSERT INTO revision (blob_hash, project_id, parent_id, author_id, message)
+	SELECT blob_hash, project_id, NULLIF($3, 0), $4, $5 FROM revision WHERE project_id=$1 AND id=$2
+	RETURNING id, blob_hash;`
+
+	var id int
+	var hash string
+	err = tx.QueryRow(q, pid, rid, head, author.ID, fmt.Sprintf("Restore revision %d", rid)).Scan(&id, &hash)
+	if err == sql.ErrNoRows {
+		return 0, ErrNoFound
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	err = setHead(tx, pid, MainBranch, id)
+	if err != nil {
+		return 0, err
+	}
+
+	content, err := loadBlob(tx, hash)
+	if err != nil {
+		return 0, err
+	}
+
+	err = indexContent(tx, pid, content)
+	if err != nil {
+		return 0, err
+	}
+
+	branch := MainBranch
+	return id, s.commitEvent(tx, events.Event{ProjectID: pid, Type: events.TypeRevision, RevisionID: &id, Branch: &branch})
 }
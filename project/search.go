@@ -0,0 +1,227 @@
+package project
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Fields projects can be sorted on.
+const (
+	SortTouched  = "touched"
+	SortName     = "name"
+	SortCreated  = "created"
+	SortModified = "modified"
+)
+
+// sortExprs are the SQL expressions sorted on. They may not be NULL, because
+// the cursor is compared against them.
+var sortExprs = map[string]string{
+	SortTouched:  `GREATEST(project.modtime, revision.created, project.created)`,
+	SortName:     `project.name`,
+	SortCreated:  `project.created`,
+	SortModified: `COALESCE(project.modtime, project.created)`,
+}
+
+// sortTypes are the SQL types of the sort expressions, which the key of a
+// cursor is cast to.
+var sortTypes = map[string]string{
+	SortTouched:  `timestamp`,
+	SortName:     `text`,
+	SortCreated:  `timestamp`,
+	SortModified: `timestamp`,
+}
+
+func ValidSort(field string) bool {
+	_, ok := sortExprs[field]
+	return ok
+}
+
+// SearchOptions filter, sort and page the projects Search returns. The zero
+// value lists everything public, most recently touched first.
+type SearchOptions struct {
+	// Viewer is the user searching, 0 if anonymous.
+	Viewer uint
+
+	// Query has to be part of the name, ignoring case.
+	Query        string
+	Author       uint
+	Organization int
+	// Upstream only finds forks of that project.
+	Upstream int
+	// Template only finds templates.
+	Template bool
+	// TouchedSince leaves out projects that haven't been changed since.
+	TouchedSince time.Time
+
+	// Sort is one of the Sort constants, SortTouched if empty.
+	Sort string
+	// Asc sorts ascending instead of descending.
+	Asc bool
+
+	// Limit is the size of a page, or 0 for everything at once.
+	Limit int
+	// Cursor is where the previous page ended, or empty for the first.
+	Cursor string
+
+	WithContent bool
+}
+
+var ErrInvalidCursor = errors.New("invalid cursor")
+
+// cursorTimeLayout is how Postgres formats timestamps as text, as they are in
+// the key of a cursor.
+const cursorTimeLayout = "2006-01-02 15:04:05.999999999"
+
+// cursor points after the last project of a page. It remembers how the
+// projects were sorted, so it can't be used with different options.
+type cursor struct {
+	Sort string `json:"s"`
+	Asc  bool   `json:"a"`
+	Key  string `json:"k"`
+	ID   int    `json:"i"`
+}
+
+func (c cursor) encode() string {
+	data, _ := json.Marshal(c)
+	return base64.RawURLEncoding.EncodeToString(data)
+}
+
+func decodeCursor(s string) (cursor, error) {
+	c := cursor{}
+
+	data, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return c, ErrInvalidCursor
+	}
+
+	if err := json.Unmarshal(data, &c); err != nil {
+		return c, ErrInvalidCursor
+	}
+
+	// The key has to cast to the type sorted on, or the query fails.
+	switch sortTypes[c.Sort] {
+	case "text":
+		if strings.ContainsRune(c.Key, 0) {
+			return c, ErrInvalidCursor
+		}
+	case "timestamp":
+		if _, err := time.Parse(cursorTimeLayout, c.Key); err != nil {
+			return c, ErrInvalidCursor
+		}
+	default:
+		return c, ErrInvalidCursor
+	}
+
+	return c, nil
+}
+
+func (s PostgresStore) Search(opts SearchOptions) ([]Project, string, error) {
+	if opts.Sort == "" {
+		opts.Sort = SortTouched
+	}
+	if !ValidSort(opts.Sort) {
+		return []Project{}, "", errors.New("invalid sort field " + opts.Sort)
+	}
+
+	expr := sortExprs[opts.Sort]
+
+	args := []interface{}{opts.Viewer}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return "$" + strconv.Itoa(len(args))
+	}
+
+	where := `project.deleted IS NULL AND ` + visibleTo("1", `'public'`)
+
+	if opts.Query != "" {
+		where += ` AND strpos(lower(project.name), lower(` + arg(opts.Query) + `)) > 0`
+	}
+	if opts.Author != 0 {
+		where += ` AND project.author_id = ` + arg(opts.Author)
+	}
+	if opts.Organization != 0 {
+		where += ` AND project.organization_id = ` + arg(opts.Organization)
+	}
+	if opts.Template {
+		where += ` AND project.template`
+	}
+	if opts.Upstream != 0 {
+		where += ` AND project.upstream_id = ` + arg(opts.Upstream)
+	}
+	if !opts.TouchedSince.IsZero() {
+		where += ` AND ` + sortExprs[SortTouched] + ` >= ` + arg(opts.TouchedSince)
+	}
+
+	dir, cmp := `DESC`, `<`
+	if opts.Asc {
+		dir, cmp = `ASC`, `>`
+	}
+
+	if opts.Cursor != "" {
+		c, err := decodeCursor(opts.Cursor)
+		if err != nil {
+			return []Project{}, "", err
+		}
+		if c.Sort != opts.Sort || c.Asc != opts.Asc {
+			return []Project{}, "", ErrInvalidCursor
+		}
+
+		where += ` AND (` + expr + `, project.id) ` + cmp + ` (` + arg(c.Key) + `::` + sortTypes[opts.Sort] + `, ` + arg(c.ID) + `)`
+	}
+
+	columns := revisionColumnsNoContent
+	if opts.WithContent {
+		columns = revisionColumns
+	}
+
+	q := `SELECT ` + projectColumns + `, ` + columns + `, (` + expr + `)::text
+	FROM project
+	` + projectJoins + `
+	WHERE ` + where + `
+	ORDER BY ` + expr + ` ` + dir + `, project.id ` + dir
+
+	// Fetch one more than asked for, to know whether there's a next page.
+	if opts.Limit > 0 {
+		q += `
+	LIMIT ` + arg(opts.Limit+1)
+	}
+
+	rows, err := s.DB.Query(q, args...)
+	if err != nil {
+		return []Project{}, "", err
+	}
+	defer rows.Close()
+
+	ps := []Project{}
+	next := ""
+	lastKey := ""
+
+	for rows.Next() {
+		row := projectRow{}
+		var key string
+
+		err := rows.Scan(append(row.fields(), &key)...)
+		if err != nil {
+			return ps, "", err
+		}
+
+		if opts.Limit > 0 && len(ps) == opts.Limit {
+			next = cursor{opts.Sort, opts.Asc, lastKey, ps[len(ps)-1].ID}.encode()
+			break
+		}
+
+		p, err := row.project(s.DB)
+		if err != nil {
+			return ps, "", err
+		}
+
+		ps = append(ps, p)
+		lastKey = key
+	}
+
+	return ps, next, rows.Err()
+}
@@ -0,0 +1,205 @@
+package project
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/frengine/server/auth"
+	"github.com/frengine/server/events"
+	"github.com/lib/pq"
+)
+
+// Transfer is a proposal to hand a project over to another user, who becomes
+// its author once they accept. A project has at most one pending transfer.
+type Transfer struct {
+	ID          int        `json:"id"`
+	ProjectID   int        `json:"project"`
+	ProjectName string     `json:"projectName"`
+	From        *auth.User `json:"from"`
+	To          *auth.User `json:"to"`
+
+	Created    *time.Time `json:"-"`
+	CreatedUTS int64      `json:"created"`
+}
+
+// ProposeTransfer proposes to hand the project over from its current author
+// to another user, replacing any earlier proposal for it. Projects of an
+// organization aren't found, they move with Move.
+func (s PostgresStore) ProposeTransfer(pid int, to auth.User) (int, error) {
+	q := `INSERT INTO transfer (project_id, from_id, to_id)
+	SELECT id, author_id, $2 FROM project WHERE id = $1 AND deleted IS NULL AND organization_id IS NULL
+	ON CONFLICT (project_id) DO UPDATE SET from_id = EXCLUDED.from_id, to_id = EXCLUDED.to_id, created = NOW()
+	RETURNING id;`
+
+	var id int
+	err := s.DB.QueryRow(q, pid, to.ID).Scan(&id)
+	if err == sql.ErrNoRows {
+		return 0, ErrNoFound
+	}
+	if err != nil {
+		pqErr, ok := err.(*pq.Error)
+		if ok && pqErr.Code == "23503" {
+			return 0, ErrInvalidUser
+		}
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// FetchTransfers returns the transfers waiting for the user to accept them.
+func (s PostgresStore) FetchTransfers(uid uint) ([]Transfer, error) {
+	q := `SELECT transfer.id, project.id, project.name, from_account.id, from_account.login, to_account.id, to_account.login, transfer.created
+	FROM transfer
+	INNER JOIN project
+		ON project.id = transfer.project_id
+	INNER JOIN account AS from_account
+		ON from_account.id = transfer.from_id
+	INNER JOIN account AS to_account
+		ON to_account.id = transfer.to_id
+	WHERE transfer.to_id = $1 AND project.deleted IS NULL
+	ORDER BY transfer.created DESC, transfer.id DESC;`
+
+	rows, err := s.DB.Query(q, uid)
+	if err != nil {
+		return []Transfer{}, err
+	}
+	defer rows.Close()
+
+	ts := []Transfer{}
+
+	for rows.Next() {
+		t := Transfer{From: &auth.User{}, To: &auth.User{}}
+
+		err := rows.Scan(&t.ID, &t.ProjectID, &t.ProjectName, &t.From.ID, &t.From.Name, &t.To.ID, &t.To.Name, &t.Created)
+		if err != nil {
+			return ts, err
+		}
+
+		if t.Created != nil {
+			t.CreatedUTS = t.Created.Unix()
+		}
+
+		ts = append(ts, t)
+	}
+
+	return ts, rows.Err()
+}
+
+// AcceptTransfer makes the user the author of the project of a transfer to
+// them. If there's no such transfer, or the project changed hands since it
+// was proposed, ErrNoFound is returned.
+func (s PostgresStore) AcceptTransfer(id int, uid uint) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	q := `SELECT project.id, project.name, project.author_id = transfer.from_id AND project.organization_id IS NULL
+	FROM transfer
+	INNER JOIN project
+		ON project.id = transfer.project_id
+	WHERE transfer.id = $1 AND transfer.to_id = $2 AND project.deleted IS NULL
+	FOR UPDATE;`
+
+	var pid int
+	var name string
+	var valid bool
+
+	err = tx.QueryRow(q, id, uid).Scan(&pid, &name, &valid)
+	if err == sql.ErrNoRows {
+		return ErrNoFound
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM transfer WHERE id = $1;`, id)
+	if err != nil {
+		return err
+	}
+
+	if !valid {
+		// Someone else got it in the meantime, so the transfer is void.
+		if err := tx.Commit(); err != nil {
+			return err
+		}
+		return ErrNoFound
+	}
+
+	_, err = tx.Exec(`UPDATE project SET author_id = $2, modtime = NOW() WHERE id = $1;`, pid, uid)
+	if err != nil {
+		return err
+	}
+
+	// The author is an owner anyway.
+	_, err = tx.Exec(`DELETE FROM project_member WHERE project_id = $1 AND account_id = $2;`, pid, uid)
+	if err != nil {
+		return err
+	}
+
+	return s.commitEvent(tx, events.Event{ProjectID: pid, Type: events.TypeUpdate, Name: &name})
+}
+
+// DeclineTransfer turns down a transfer to the user. If there's no such
+// transfer, ErrNoFound is returned.
+func (s PostgresStore) DeclineTransfer(id int, uid uint) error {
+	result, err := s.DB.Exec(`DELETE FROM transfer WHERE id = $1 AND to_id = $2;`, id, uid)
+	if err != nil {
+		return err
+	}
+
+	return expectRows(result)
+}
+
+// CancelTransfer withdraws the pending transfer of a project. If there is
+// none, ErrNoFound is returned.
+func (s PostgresStore) CancelTransfer(pid int) error {
+	result, err := s.DB.Exec(`DELETE FROM transfer WHERE project_id = $1;`, pid)
+	if err != nil {
+		return err
+	}
+
+	return expectRows(result)
+}
+
+// Move hands the project to the organization. With oid 0 it takes the project
+// out of its organization instead, and the user becomes its author. Any
+// pending transfer is dropped.
+func (s PostgresStore) Move(pid int, oid int, author auth.User) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	q := `UPDATE project
+	SET organization_id = NULLIF($2, 0), author_id = CASE WHEN $2 = 0 THEN $3 ELSE author_id END, modtime = NOW()
+	WHERE id = $1 AND deleted IS NULL
+	RETURNING name;`
+
+	var name string
+	err = tx.QueryRow(q, pid, oid, author.ID).Scan(&name)
+	if err == sql.ErrNoRows {
+		return ErrNoFound
+	}
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.Exec(`DELETE FROM transfer WHERE project_id = $1;`, pid)
+	if err != nil {
+		return err
+	}
+
+	if oid == 0 {
+		// The author is an owner anyway.
+		_, err = tx.Exec(`DELETE FROM project_member WHERE project_id = $1 AND account_id = $2;`, pid, author.ID)
+		if err != nil {
+			return err
+		}
+	}
+
+	return s.commitEvent(tx, events.Event{ProjectID: pid, Type: events.TypeUpdate, Name: &name})
+}
@@ -0,0 +1,193 @@
+package project
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/frengine/server/events"
+	"github.com/lib/pq"
+)
+
+// FetchTrash returns the deleted projects the user owns, most recently
+// deleted first.
+func (s PostgresStore) FetchTrash(uid uint) ([]Project, error) {
+	q := `SELECT ` + projectColumns + `, ` + revisionColumnsNoContent + `
+	FROM project
+	` + projectJoins + `
+	WHERE project.deleted IS NOT NULL AND (` + ownedByAuthor("1") + ` OR EXISTS (
+		SELECT 1 FROM project_member WHERE project_member.project_id = project.id AND project_member.account_id = $1 AND project_member.role = 'owner'
+	) OR EXISTS (
+		SELECT 1 FROM organization_member WHERE organization_member.organization_id = project.organization_id AND organization_member.account_id = $1 AND organization_member.role = 'owner'
+	))
+	ORDER BY project.deleted DESC, project.id DESC;`
+
+	rows, err := s.DB.Query(q, uid)
+	if err != nil {
+		return []Project{}, err
+	}
+	defer rows.Close()
+
+	ps := []Project{}
+
+	for rows.Next() {
+		row := projectRow{}
+
+		err := rows.Scan(row.fields()...)
+		if err != nil {
+			return ps, err
+		}
+
+		p, err := row.project(s.DB)
+		if err != nil {
+			return ps, err
+		}
+
+		ps = append(ps, p)
+	}
+
+	return ps, rows.Err()
+}
+
+// Undelete takes a project out of the trash. If it isn't in there,
+// ErrNoFound is returned.
+func (s PostgresStore) Undelete(id int) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec(`UPDATE project SET deleted = NULL WHERE id = $1 AND deleted IS NOT NULL;`, id)
+	if err != nil {
+		return err
+	}
+
+	err = expectRows(result)
+	if err != nil {
+		return err
+	}
+
+	return s.commitEvent(tx, events.Event{ProjectID: id, Type: events.TypeUndelete})
+}
+
+// Purge removes a project in the trash with everything that belongs to it,
+// for good. If it isn't in the trash, ErrNoFound is returned.
+func (s PostgresStore) Purge(id int) error {
+	tx, err := s.DB.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = tx.QueryRow(`SELECT id FROM project WHERE id = $1 AND deleted IS NOT NULL FOR UPDATE;`, id).Scan(&id)
+	if err == sql.ErrNoRows {
+		return ErrNoFound
+	}
+	if err != nil {
+		return err
+	}
+
+	var hashes []string
+	err = tx.QueryRow(`SELECT COALESCE(array_agg(DISTINCT blob_hash), '{}') FROM revision WHERE project_id = $1 AND blob_hash IS NOT NULL;`, id).Scan(pq.Array(&hashes))
+	if err != nil {
+		return err
+	}
+
+	for _, q := range []string{
+		`DELETE FROM event WHERE project_id = $1;`,
+		`DELETE FROM lease WHERE project_id = $1;`,
+		`DELETE FROM transfer WHERE project_id = $1;`,
+		`DELETE FROM project_member WHERE project_id = $1;`,
+		`DELETE FROM ref WHERE project_id = $1;`,
+		`DELETE FROM revision WHERE project_id = $1;`,
+		`UPDATE project SET upstream_id = NULL WHERE upstream_id = $1;`,
+		`DELETE FROM project WHERE id = $1;`,
+	} {
+		_, err := tx.Exec(q, id)
+		if err != nil {
+			return err
+		}
+	}
+
+	err = purgeBlobs(tx, hashes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// purgeBlobs removes the blobs no revision or delta uses anymore, and then
+// the delta bases of those that were removed, if they became unused.
+func purgeBlobs(tx *sql.Tx, hashes []string) error {
+	q := `DELETE FROM blob
+	WHERE hash = ANY($1)
+		AND NOT EXISTS (SELECT 1 FROM revision WHERE revision.blob_hash = blob.hash)
+		AND NOT EXISTS (SELECT 1 FROM blob AS derived WHERE derived.delta_base = blob.hash)
+	RETURNING delta_base;`
+
+	for len(hashes) > 0 {
+		rows, err := tx.Query(q, pq.Array(hashes))
+		if err != nil {
+			return err
+		}
+
+		hashes = hashes[:0]
+		for rows.Next() {
+			var base sql.NullString
+			if err := rows.Scan(&base); err != nil {
+				rows.Close()
+				return err
+			}
+			if base.Valid {
+				hashes = append(hashes, base.String)
+			}
+		}
+		rows.Close()
+
+		if err := rows.Err(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// PurgeDeletedBefore purges every project deleted before t, and returns how
+// many there were.
+func (s PostgresStore) PurgeDeletedBefore(t time.Time) (int, error) {
+	rows, err := s.DB.Query(`SELECT id FROM project WHERE deleted < $1;`, t)
+	if err != nil {
+		return 0, err
+	}
+
+	ids := []int{}
+	for rows.Next() {
+		var id int
+		if err := rows.Scan(&id); err != nil {
+			rows.Close()
+			return 0, err
+		}
+		ids = append(ids, id)
+	}
+	rows.Close()
+
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	purged := 0
+	for _, id := range ids {
+		err := s.Purge(id)
+		if err == ErrNoFound {
+			// Restored or purged in the meantime.
+			continue
+		}
+		if err != nil {
+			return purged, err
+		}
+		purged++
+	}
+
+	return purged, nil
+}